@@ -0,0 +1,54 @@
+package docker
+
+import "fmt"
+
+const (
+	// labels set by docker compose on the containers
+	composeLabelProject         = "com.docker.compose.project"
+	composeLabelService         = "com.docker.compose.service"
+	composeLabelContainerNumber = "com.docker.compose.container-number"
+
+	dockerLabelComposePrefix          = dockerLabel + "compose_"
+	dockerLabelComposeProject         = dockerLabelComposePrefix + "project"
+	dockerLabelComposeService         = dockerLabelComposePrefix + "service"
+	dockerLabelComposeContainerNumber = dockerLabelComposePrefix + "container_number"
+)
+
+// InstanceNaming is how the container part of the instance label is formed
+type InstanceNaming string
+
+const (
+	InstanceByContainerName InstanceNaming = "container-name" // name of the container
+	InstanceByCompose       InstanceNaming = "compose"        // <project>/<service>/<container number> for compose containers, otherwise the container name
+)
+
+func ParseInstanceNaming(s string) (InstanceNaming, error) {
+	switch n := InstanceNaming(s); n {
+	case InstanceByContainerName, InstanceByCompose:
+		return n, nil
+	default:
+		return "", fmt.Errorf("invalid instance naming '%s', must be one of %s or %s", s, InstanceByContainerName, InstanceByCompose)
+	}
+}
+
+// add the compose project, service and container number, when the container is from docker compose
+func addComposeLabels(labels map[string]string, containerLabels map[string]string) {
+	for from, to := range map[string]string{
+		composeLabelProject:         dockerLabelComposeProject,
+		composeLabelService:         dockerLabelComposeService,
+		composeLabelContainerNumber: dockerLabelComposeContainerNumber} {
+		if v, exists := containerLabels[from]; exists {
+			labels[to] = v
+		}
+	}
+}
+
+// name of the container as '/<project>/<service>/<container number>'. This is
+// stable when compose renames or recreates the container. False if not from compose
+func composeName(labels map[string]string) (string, bool) {
+	project, service, number := labels[dockerLabelComposeProject], labels[dockerLabelComposeService], labels[dockerLabelComposeContainerNumber]
+	if project == "" || service == "" || number == "" {
+		return "", false
+	}
+	return "/" + project + "/" + service + "/" + number, true
+}
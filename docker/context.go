@@ -0,0 +1,177 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/common/config"
+)
+
+const (
+	envDockerContext = "DOCKER_CONTEXT"
+	envDockerConfig  = "DOCKER_CONFIG"
+	envXDGRuntimeDir = "XDG_RUNTIME_DIR"
+
+	defaultContextName = "default"
+)
+
+// Endpoint of a Docker host, with the TLS settings to connect with
+type Endpoint struct {
+	Host      string
+	TLSConfig config.TLSConfig
+	// where the endpoint was resolved from, e.g. "DOCKER_HOST" or "context remote1"
+	Source string
+}
+
+func (e Endpoint) String() string {
+	return fmt.Sprintf("%s (%s)", e.Host, e.Source)
+}
+
+// ResolveEndpoint resolves the Docker host the way the docker CLI does. In
+// order: the given host, the given context, DOCKER_HOST, DOCKER_CONTEXT, the
+// current context of the docker CLI config, the rootless socket in
+// XDG_RUNTIME_DIR (if present), and at last the system socket. TLS settings
+// are from the context, otherwise from DOCKER_CERT_PATH and DOCKER_TLS_VERIFY
+func ResolveEndpoint(host, contextName string) (Endpoint, error) {
+	if host != "" {
+		return envEndpoint(host, "docker-host"), nil
+	}
+	if contextName != "" {
+		return ResolveContext(contextName)
+	}
+	if host := os.Getenv(envDockerHost); host != "" {
+		return envEndpoint(host, envDockerHost), nil
+	}
+	if name := os.Getenv(envDockerContext); name != "" {
+		return ResolveContext(name)
+	}
+
+	name, err := currentContext()
+	if err != nil {
+		return Endpoint{}, err
+	}
+	if name != "" && name != defaultContextName {
+		return ResolveContext(name)
+	}
+	return defaultEndpoint(), nil
+}
+
+func envEndpoint(host, source string) Endpoint {
+	tlsConfig, _ := TLSConfigFromEnv()
+	return Endpoint{Host: host, TLSConfig: tlsConfig, Source: source}
+}
+
+// rootless socket if present, otherwise the system socket
+func defaultEndpoint() Endpoint {
+	if dir := os.Getenv(envXDGRuntimeDir); dir != "" {
+		path := filepath.Join(dir, "docker.sock")
+		if _, err := os.Stat(path); err == nil {
+			return Endpoint{Host: "unix://" + path, Source: "rootless socket"}
+		}
+	}
+	return Endpoint{Host: defaultDockerHost, Source: "default"}
+}
+
+// directory of the docker CLI config, DOCKER_CONFIG or ~/.docker
+func dockerConfigDir() (string, error) {
+	if dir := os.Getenv(envDockerConfig); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate docker config: %w", err)
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// current context of the docker CLI config. Empty if not set
+func currentContext() (string, error) {
+	dir, err := dockerConfigDir()
+	if err != nil {
+		// no home, no config
+		return "", nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read docker config: %w", err)
+	}
+
+	var c struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return "", fmt.Errorf("failed to parse docker config: %w", err)
+	}
+	return c.CurrentContext, nil
+}
+
+// meta.json of a context in the docker CLI context store
+type contextMeta struct {
+	Name      string
+	Endpoints map[string]struct {
+		Host          string
+		SkipTLSVerify bool
+	}
+}
+
+// ResolveContext resolves the Docker endpoint of a docker CLI context, from
+// contexts/meta/<sha256 of name>/meta.json in the docker config dir. The TLS
+// material is ca.pem, cert.pem and key.pem in contexts/tls/<sha256 of name>/docker,
+// if present
+func ResolveContext(name string) (Endpoint, error) {
+	if name == defaultContextName {
+		if host := os.Getenv(envDockerHost); host != "" {
+			return envEndpoint(host, envDockerHost), nil
+		}
+		return defaultEndpoint(), nil
+	}
+
+	dir, err := dockerConfigDir()
+	if err != nil {
+		return Endpoint{}, err
+	}
+
+	digest := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(digest[:])
+	data, err := os.ReadFile(filepath.Join(dir, "contexts", "meta", id, "meta.json"))
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("failed to read docker context '%s': %w", name, err)
+	}
+
+	var meta contextMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return Endpoint{}, fmt.Errorf("failed to parse docker context '%s': %w", name, err)
+	}
+	e, found := meta.Endpoints["docker"]
+	if !found || e.Host == "" {
+		return Endpoint{}, fmt.Errorf("docker context '%s' has no docker endpoint", name)
+	}
+
+	result := Endpoint{Host: e.Host, Source: "context " + name}
+	tlsDir := filepath.Join(dir, "contexts", "tls", id, "docker")
+	if file := filepath.Join(tlsDir, "ca.pem"); exists(file) {
+		result.TLSConfig.CAFile = file
+	}
+	if file := filepath.Join(tlsDir, "cert.pem"); exists(file) {
+		result.TLSConfig.CertFile = file
+	}
+	if file := filepath.Join(tlsDir, "key.pem"); exists(file) {
+		result.TLSConfig.KeyFile = file
+	}
+	result.TLSConfig.InsecureSkipVerify = e.SkipTLSVerify
+	return result, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
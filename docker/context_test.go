@@ -0,0 +1,118 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// write docker CLI context to the config dir
+func writeContext(dir, name, meta string, tlsFiles ...string) {
+	digest := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(digest[:])
+	metaDir := filepath.Join(dir, "contexts", "meta", id)
+	tlsDir := filepath.Join(dir, "contexts", "tls", id, "docker")
+	for _, d := range []string{metaDir, tlsDir} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			panic(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(metaDir, "meta.json"), []byte(meta), 0600); err != nil {
+		panic(err)
+	}
+	for _, f := range tlsFiles {
+		if err := os.WriteFile(filepath.Join(tlsDir, f), []byte("pem"), 0600); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestResolveEndpoint(t *testing.T) {
+	Convey("given docker config with context 'remote1' and no environment", t, func() {
+		dir := t.TempDir()
+		t.Setenv(envDockerConfig, dir)
+		t.Setenv(envDockerHost, "")
+		t.Setenv(envDockerContext, "")
+		t.Setenv(envDockerCertPath, "")
+		t.Setenv(envDockerTLSVerify, "")
+		t.Setenv(envXDGRuntimeDir, "")
+		writeContext(dir, "remote1",
+			`{"Name":"remote1","Metadata":{},"Endpoints":{"docker":{"Host":"tcp://remote1:2376","SkipTLSVerify":false}}}`,
+			"ca.pem", "cert.pem", "key.pem")
+
+		Convey("should default to the system socket", func() {
+			e, err := ResolveEndpoint("", "")
+			So(err, ShouldBeNil)
+			So(e.Host, ShouldEqual, "unix:///var/run/docker.sock")
+			So(e.Source, ShouldEqual, "default")
+		})
+
+		Convey("with explicit context, should resolve host and TLS files", func() {
+			e, err := ResolveEndpoint("", "remote1")
+			So(err, ShouldBeNil)
+			So(e.Host, ShouldEqual, "tcp://remote1:2376")
+			So(e.Source, ShouldEqual, "context remote1")
+			So(e.TLSConfig.CAFile, ShouldEndWith, filepath.Join("docker", "ca.pem"))
+			So(e.TLSConfig.CertFile, ShouldEndWith, filepath.Join("docker", "cert.pem"))
+			So(e.TLSConfig.KeyFile, ShouldEndWith, filepath.Join("docker", "key.pem"))
+			So(e.TLSConfig.InsecureSkipVerify, ShouldBeFalse)
+		})
+
+		Convey("with unknown context, should fail", func() {
+			_, err := ResolveEndpoint("", "other")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("with explicit host, should take precedence over context", func() {
+			e, err := ResolveEndpoint("tcp://host1:2375", "remote1")
+			So(err, ShouldBeNil)
+			So(e.Host, ShouldEqual, "tcp://host1:2375")
+		})
+
+		Convey("with current context in config, should resolve it", func() {
+			So(os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"currentContext":"remote1"}`), 0600), ShouldBeNil)
+			e, err := ResolveEndpoint("", "")
+			So(err, ShouldBeNil)
+			So(e.Host, ShouldEqual, "tcp://remote1:2376")
+
+			Convey("and DOCKER_HOST, should take precedence", func() {
+				t.Setenv(envDockerHost, "tcp://host2:2375")
+				e, err := ResolveEndpoint("", "")
+				So(err, ShouldBeNil)
+				So(e.Host, ShouldEqual, "tcp://host2:2375")
+				So(e.Source, ShouldEqual, envDockerHost)
+			})
+		})
+
+		Convey("with DOCKER_CONTEXT, should resolve it", func() {
+			t.Setenv(envDockerContext, "remote1")
+			e, err := ResolveEndpoint("", "")
+			So(err, ShouldBeNil)
+			So(e.Host, ShouldEqual, "tcp://remote1:2376")
+		})
+
+		Convey("with rootless socket in XDG_RUNTIME_DIR, should use it", func() {
+			runtimeDir := t.TempDir()
+			t.Setenv(envXDGRuntimeDir, runtimeDir)
+			So(os.WriteFile(filepath.Join(runtimeDir, "docker.sock"), nil, 0600), ShouldBeNil)
+
+			e, err := ResolveEndpoint("", "")
+			So(err, ShouldBeNil)
+			So(e.Host, ShouldEqual, "unix://"+filepath.Join(runtimeDir, "docker.sock"))
+		})
+
+		Convey("with DOCKER_HOST and DOCKER_CERT_PATH, should use the TLS files of the path", func() {
+			t.Setenv(envDockerHost, "tcp://host2:2376")
+			t.Setenv(envDockerCertPath, "/certs")
+			t.Setenv(envDockerTLSVerify, "1")
+			e, err := ResolveEndpoint("", "")
+			So(err, ShouldBeNil)
+			So(e.TLSConfig.CAFile, ShouldEqual, "/certs/ca.pem")
+			So(e.TLSConfig.InsecureSkipVerify, ShouldBeFalse)
+		})
+	})
+}
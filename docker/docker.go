@@ -24,94 +24,208 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/util/strutil"
 )
 
 const (
 	dockerLabel                     = model.MetaLabelPrefix + "docker_"
+	dockerLabelHost                 = dockerLabel + "host"
 	dockerLabelContainerPrefix      = dockerLabel + "container_"
 	dockerLabelContainerID          = dockerLabelContainerPrefix + "id"
 	dockerLabelContainerName        = dockerLabelContainerPrefix + "name"
 	dockerLabelContainerState       = dockerLabelContainerPrefix + "state"
+	dockerLabelContainerHealth      = dockerLabelContainerPrefix + "health"
 	dockerLabelContainerNetworkMode = dockerLabelContainerPrefix + "network_mode"
 	dockerLabelContainerLabelPrefix = dockerLabelContainerPrefix + "label_"
 	dockerLabelNetworkPrefix        = dockerLabel + "network_"
 	dockerLabelNetworkIP            = dockerLabelNetworkPrefix + "ip"
+	dockerLabelNetworkIPv6          = dockerLabelNetworkPrefix + "ipv6"
+	dockerLabelNetworkName          = dockerLabelNetworkPrefix + "name"
 	dockerLabelPortPrefix           = dockerLabel + "port_"
 	dockerLabelPortPrivate          = dockerLabelPortPrefix + "private"
 	dockerLabelPortPublic           = dockerLabelPortPrefix + "public"
 	dockerLabelPortPublicIP         = dockerLabelPortPrefix + "public_ip"
 	userAgent                       = "github.com/bredtape/prometheus_docker_sd"
-	extractLabelPrefix              = "prometheus_"
-	jobLabelPrefix                  = extractLabelPrefix + "job"
-	extractScrapePrefix             = "prometheus_scrape_"
-	scrapePort                      = extractScrapePrefix + "port"
-	scrapeInterval                  = extractScrapePrefix + "interval"
-	scrapeTimeout                   = extractScrapePrefix + "timeout"
-	scrapePath                      = extractScrapePrefix + "path"
-	scrapeScheme                    = extractScrapePrefix + "scheme"
-	scrapeExternal                  = extractScrapePrefix + "external"
-	fakeIP                          = "1.1.1.1"
+	endpointJob                     = "job"
+	endpointPort                    = "port"
+	// marker host for containers without an IP address. The .invalid TLD never resolves (RFC 6761),
+	// so the target is kept (up=0), rather than scraping something else
+	noAddressHost = "no-address.invalid"
 )
 
+// settings of named endpoints, <prefix>endpoint_<name>_<setting>, other than job and port
+var endpointSettings = map[string]string{
+	"interval": model.ScrapeIntervalLabel,
+	"timeout":  model.ScrapeTimeoutLabel,
+	"path":     model.MetricsPathLabel,
+	"scheme":   model.SchemeLabel}
+
 type Meta struct {
-	Name    string
-	Address string
-	Labels  map[string]string
-
-	HasJob            bool
-	IsInTargetNetwork bool
-	HasTCPPorts       bool // at least 1 TCP port
-	HasExplicitPort   bool // explicit or single port
-	ScrapeExternal    bool
+	ID       string // container ID
+	Name     string
+	State    string // container state, e.g. running
+	Endpoint string // named endpoint, empty for the default endpoint
+	Network  string // target network the address is from
+	Address  string
+	Labels   map[string]string
+
+	HasJob                  bool
+	JobDerived              bool   // job derived in opt-out mode, rather than from the job label
+	IsInTargetNetwork       bool   // in target network, or the scrape network when set
+	HasInvalidScrapeNetwork bool   // prometheus_scrape_network set, but the container is not a member of it
+	IsHostNetwork           bool   // network_mode host, scraped on the host address
+	ExcludedReason          string // excluded by the export policy, e.g. 'container state exited'
+	NoIPAddress             bool   // in the target network, but without IP address, e.g. when restarting
+	NoAddressInFamily       bool   // in the target network with an IP address, but none of the address family
+	IsStale                 bool   // disappeared or lost its address, but held in the grace period
+	HasTCPPorts             bool   // at least 1 TCP port
+	HasExplicitPort         bool   // explicit or single port
+	ScrapeExternal          bool
+	DroppedLabels           int    // container labels dropped by the label filter
+	DroppedByRelabelRule    int    // 1-based index of the relabel rule, that dropped the target. 0 when kept
+	TemplateError           string // failed to render the instance or external address template
+	Problems                []Problem
+	IsProbe                 bool // blackbox exporter probe of the container, rather than a scrape
+
+	probe *probe // probe settings of the container, on the default endpoint
 }
 
-// whether the Container is exported
+// whether the Container (endpoint) is exported
 func (m Meta) IsExported() bool {
-	return m.HasJob && m.IsInTargetNetwork && m.HasTCPPorts
+	return m.HasJob && (m.IsInTargetNetwork || m.IsHostNetwork) && m.HasTCPPorts && m.ExcludedReason == "" && m.Address != "" && m.DroppedByRelabelRule == 0 && m.TemplateError == "" && !hasErrors(m.Problems)
+}
+
+// Reason is a short code of why the target is not exported, or exported with
+// a warning, e.g. not_in_target_network. Empty when exported without warnings
+func (m Meta) Reason() string {
+	switch {
+	case !m.HasJob:
+		return "no_job"
+	case m.IsStale:
+		return "stale"
+	case m.ExcludedReason != "":
+		return "excluded"
+	case m.DroppedByRelabelRule > 0:
+		return "relabel_dropped"
+	case m.TemplateError != "":
+		return "template_error"
+	case m.HasInvalidScrapeNetwork:
+		return "invalid_scrape_network"
+	case !m.IsInTargetNetwork && !m.IsHostNetwork:
+		return "not_in_target_network"
+	case !m.HasTCPPorts:
+		return "no_tcp_ports"
+	case m.NoAddressInFamily:
+		return "no_address_in_family"
+	}
+	for _, p := range m.Problems {
+		if !p.Reason.IsWarning() {
+			return string(p.Reason)
+		}
+	}
+	switch {
+	case m.NoIPAddress || m.Address == "":
+		return "no_ip_address"
+	case len(m.Problems) > 0:
+		return string(m.Problems[0].Reason)
+	case !m.HasExplicitPort:
+		return "multiple_ports"
+	}
+	return ""
 }
 
-// Config is the configuration for Docker (non-swarm) based service discovery.
+// Config is the configuration for Docker based service discovery.
 type Config struct {
+	// containers of a single host (default) or tasks of swarm services
+	Mode             Mode
 	HTTPClientConfig config.HTTPClientConfig `yaml:",inline"`
 	// docker host url, e.g. unix:///var/run/docker.sock
 	DockerHost      string        `yaml:"host"`
 	RefreshInterval time.Duration `yaml:"refresh_interval"`
 
+	// whether to subscribe to the Docker events stream and refresh when containers change
+	WatchEvents bool
+	// period to collect a burst of events before triggering a refresh
+	EventDebounce time.Duration
+
 	// external host. To be used with targets that require external scraping
 	ExternalHost string
 
 	// prefix for instance. The Container name is appended
 	InstancePrefix string
-	// network that the Container must be a member of
-	TargetNetwork string
+	// prefix of the container labels to extract. Defaults to prometheus_
+	LabelPrefix string
+	// how the container part of the instance is formed. Defaults to the container name
+	InstanceNaming InstanceNaming
+	// template of the instance label, see TemplateData. Takes precedence over InstancePrefix and InstanceNaming
+	InstanceTemplate *template.Template
+	// template of the address of targets scraped via the external host, see TemplateData. Defaults to <ExternalHost>:<port>
+	ExternalAddressTemplate *template.Template
+	// whether to read the prometheus.io/* labels, with the prometheus_* labels taking precedence
+	PrometheusIO bool
+	// job of containers with prometheus.io/scrape=true, but without prometheus_job
+	PrometheusIOJob string
+	// export all containers in the target networks, unless opted out with prometheus_scrape=false
+	OptOut bool
+	// what the job of containers without prometheus_job is derived from, in opt-out mode
+	JobSource JobSource
+	// address of the blackbox exporter, to probe containers with prometheus_probe_module on. Empty to disable probes
+	ProbeAddress string
+	// job of the probe targets, unless prometheus_probe_job is set
+	ProbeJob string
+	// networks that the Container must be a member of (at least 1)
+	TargetNetworks TargetNetworks
+	// IP address family of the scrape address. Defaults to IPv4
+	AddressFamily AddressFamily
+	// address of containers with network_mode host. Defaults to ExternalHost
+	HostNetworkAddress string
+	// Docker labels of containers and networks to add as meta labels
+	LabelFilter LabelFilter
+	// drop targets by container state and health
+	ExportPolicy ExportPolicy
+	// policy for containers in the target network without IP address. Defaults to marker
+	NoAddressPolicy NoAddressPolicy
+	// period to keep emitting targets, that disappeared or lost their address. Zero to disable
+	GracePeriod time.Duration
+	// Prometheus relabel rules applied to the exported targets, in order
+	RelabelConfigs []*relabel.Config
 }
 
 type Discovery struct {
-	client         *client.Client
-	instancePrefix string
-	externalHost   string
-	targetNetwork  string
-	log            *slog.Logger
+	client    *client.Client
+	conf      Config
+	lastKnown map[string]string      // last known address by container ID and endpoint
+	grace     map[string]graceTarget // exported targets by container ID and endpoint
+	log       *slog.Logger
+}
+
+type graceTarget struct {
+	meta     Meta
+	lastSeen time.Time
 }
 
 func New(conf *Config) (*Discovery, error) {
 	var err error
 
 	d := &Discovery{
-		instancePrefix: conf.InstancePrefix,
-		targetNetwork:  conf.TargetNetwork,
-		externalHost:   conf.ExternalHost,
+		conf:      *conf,
+		lastKnown: make(map[string]string),
+		grace:     make(map[string]graceTarget),
 		log: slog.Default().With(
-			"targetNetwork", conf.TargetNetwork,
-			"instancePrefix", conf.InstancePrefix)}
+			"dockerHost", conf.DockerHost,
+			"targetNetworks", conf.TargetNetworks.String(),
+			"instancePrefix", conf.InstancePrefix,
+			"addressFamily", conf.AddressFamily)}
 
 	hostURL, err := url.Parse(conf.DockerHost)
 	if err != nil {
@@ -123,20 +237,38 @@ func New(conf *Config) (*Discovery, error) {
 		client.WithAPIVersionNegotiation(),
 	}
 
+	// the Docker client does not support ssh, so the API is tunnelled through
+	// the ssh command, with a placeholder HTTP host
+	if hostURL.Scheme == "ssh" {
+		dialer, err := newSSHDialer(d.log, hostURL)
+		if err != nil {
+			return nil, err
+		}
+		opts = []client.Opt{
+			client.WithHost(sshClientHost),
+			client.WithDialContext(dialer.DialContext),
+			client.WithAPIVersionNegotiation(),
+			client.WithHTTPHeaders(map[string]string{
+				"User-Agent": userAgent,
+			}),
+		}
+	}
+
 	// There are other protocols than HTTP supported by the Docker daemon, like
 	// unix, which are not supported by the HTTP client. Passing HTTP client
 	// options to the Docker client makes those non-HTTP requests fail.
-	if hostURL.Scheme == "http" || hostURL.Scheme == "https" {
+	if scheme, ok := httpScheme(hostURL, conf.HTTPClientConfig.TLSConfig); ok {
 		rt, err := config.NewRoundTripperFromConfig(conf.HTTPClientConfig, "docker_sd")
 		if err != nil {
 			return nil, err
 		}
 		opts = append(opts,
+			// no client timeout, as it would cut the long-lived events stream.
+			// Refresh is bounded by a context timeout instead
 			client.WithHTTPClient(&http.Client{
 				Transport: rt,
-				Timeout:   time.Duration(conf.RefreshInterval),
 			}),
-			client.WithScheme(hostURL.Scheme),
+			client.WithScheme(scheme),
 			client.WithHTTPHeaders(map[string]string{
 				"User-Agent": userAgent,
 			}),
@@ -152,20 +284,187 @@ func New(conf *Config) (*Discovery, error) {
 }
 
 func (d *Discovery) Refresh(ctx context.Context) ([]Meta, error) {
+	if d.conf.RefreshInterval > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.conf.RefreshInterval)
+		defer cancel()
+	}
+
+	var xs []Meta
+	var err error
+	if d.conf.Mode == ModeSwarm {
+		xs, err = d.refreshSwarm(ctx)
+	} else {
+		xs, err = d.refreshContainers(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// distinguish targets, when discovering from several hosts
+	for _, x := range xs {
+		x.Labels[dockerLabelHost] = d.conf.DockerHost
+	}
+
+	if d.conf.NoAddressPolicy == NoAddressLastKnown {
+		d.resolveLastKnown(xs)
+		sortMetas(xs)
+	}
+	xs = addProbes(&d.conf, xs)
+	if d.conf.GracePeriod > 0 {
+		xs = d.applyGracePeriod(xs, time.Now())
+		sortMetas(xs)
+	}
+	applyRelabelConfigs(xs, d.conf.RelabelConfigs)
+	return xs, nil
+}
+
+// keep emitting targets, that disappeared or lost their address, for the grace
+// period. This avoids gaps when a container restarts between refreshes.
+// Targets without IP address are never last-good, even when exported with
+// the marker or last known address. A recreated container has a new ID, so
+// the target of the old container is dropped, rather than exported with the
+// same job and instance as the new one
+func (d *Discovery) applyGracePeriod(xs []Meta, now time.Time) []Meta {
+	current := make(map[string]int, len(xs))
+	live := make(map[string]struct{}, len(xs))
+	for i, x := range xs {
+		key := targetKey(x)
+		current[key] = i
+		if isLastGood(x) {
+			d.grace[key] = graceTarget{meta: x, lastSeen: now}
+			live[seriesKey(x)] = struct{}{}
+		}
+	}
+
+	for key, g := range d.grace {
+		i, exists := current[key]
+		if exists && (isLastGood(xs[i]) || xs[i].ExcludedReason != "") {
+			continue
+		}
+
+		if _, replaced := live[seriesKey(g.meta)]; replaced {
+			d.log.Debug("target replaced, e.g. by a recreated container", "container", g.meta.ID, "name", g.meta.Name)
+			delete(d.grace, key)
+			continue
+		}
+
+		if now.Sub(g.lastSeen) > d.conf.GracePeriod {
+			delete(d.grace, key)
+			continue
+		}
+
+		stale := g.meta
+		stale.IsStale = true
+		d.log.Debug("target held in grace period", "container", stale.ID, "name", stale.Name, "lastSeen", g.lastSeen)
+		if exists {
+			xs[i] = stale
+		} else {
+			xs = append(xs, stale)
+		}
+	}
+	return xs
+}
+
+func isLastGood(x Meta) bool {
+	return x.IsExported() && !x.NoIPAddress
+}
+
+// targets with the same job and instance write the same series
+func seriesKey(x Meta) string {
+	return x.Labels[model.JobLabel] + "\xff" + x.Labels[model.InstanceLabel]
+}
+
+func (d *Discovery) refreshContainers(ctx context.Context) ([]Meta, error) {
 	containers, err := d.client.ContainerList(ctx, container.ListOptions{All: true, Latest: true})
 	if err != nil {
 		return nil, fmt.Errorf("error while listing containers: %w", err)
 	}
 
-	networkLabels, err := getNetworksLabels(ctx, d.client, dockerLabel)
+	networkLabels, err := getNetworksLabels(ctx, d.client, dockerLabel, d.conf.LabelFilter)
 	if err != nil {
 		return nil, fmt.Errorf("error while computing network labels: %w", err)
 	}
 
-	return extract(d.log, d.instancePrefix, d.externalHost, d.targetNetwork, containers, networkLabels), nil
+	d.addExposedPorts(ctx, containers)
+
+	return extract(d.log, &d.conf, containers, networkLabels), nil
 }
 
-func extract(parentLog *slog.Logger, instancePrefix, externalHost, targetNetworkName string, containers []types.Container, networkLabels map[string]map[string]string) []Meta {
+// the container list has no ports for containers that are not running. Use
+// the exposed ports of the container (and image) instead, for restarting
+// containers only. Stopped containers are not expected to come back by themselves
+func (d *Discovery) addExposedPorts(ctx context.Context, containers []types.Container) {
+	for i := range containers {
+		c := &containers[i]
+		if c.State != StateRestarting || len(c.Ports) > 0 || !hasExtractLabels(&d.conf, c.Labels) {
+			continue
+		}
+
+		info, err := d.client.ContainerInspect(ctx, c.ID)
+		if err != nil {
+			d.log.Warn("failed to inspect container", "container", c.ID, "error", err)
+			continue
+		}
+		if info.Config == nil {
+			continue
+		}
+
+		for p := range info.Config.ExposedPorts {
+			c.Ports = append(c.Ports, types.Port{Type: p.Proto(), PrivatePort: uint16(p.Int())})
+		}
+	}
+}
+
+// resolve the last known address of targets without an IP address, and
+// remember the address of the others. Removed containers are forgotten
+func (d *Discovery) resolveLastKnown(xs []Meta) {
+	seen := make(map[string]struct{}, len(xs))
+	for i := range xs {
+		x := &xs[i]
+		key := x.ID + "/" + x.Endpoint
+		seen[key] = struct{}{}
+
+		if !x.NoIPAddress {
+			if x.Address != "" && !x.ScrapeExternal {
+				d.lastKnown[key] = x.Address
+			}
+			continue
+		}
+
+		if address, exists := d.lastKnown[key]; exists {
+			x.Address = address
+			x.Labels[model.AddressLabel] = address
+			d.log.Debug("no IP address found, using last known address", "container", x.ID, "name", x.Name, "address", address)
+		}
+	}
+
+	for key := range d.lastKnown {
+		if _, exists := seen[key]; !exists {
+			delete(d.lastKnown, key)
+		}
+	}
+}
+
+// whether any labels with the extract prefix (or prometheus.io/ when enabled)
+// are set. In opt-out mode any container may be a target
+func hasExtractLabels(conf *Config, labels map[string]string) bool {
+	if conf.OptOut {
+		return true
+	}
+	prefix := conf.labelKeys().prefix
+	for k := range labels {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+		if conf.PrometheusIO && strings.HasPrefix(k, prometheusIOPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func extract(parentLog *slog.Logger, conf *Config, containers []types.Container, networkLabels map[string]map[string]string) []Meta {
 
 	result := make([]Meta, 0)
 
@@ -178,126 +477,430 @@ func extract(parentLog *slog.Logger, instancePrefix, externalHost, targetNetwork
 			"container", c.ID,
 			"name", c.Names[0])
 
-		meta := Meta{
-			Name: c.Names[0],
-			Labels: map[string]string{
-				dockerLabelContainerID:          c.ID,
-				dockerLabelContainerName:        c.Names[0],
-				dockerLabelContainerState:       c.State,
-				dockerLabelContainerNetworkMode: c.HostConfig.NetworkMode}}
-
-		if _, exists := c.Labels[jobLabelPrefix]; exists {
-			meta.HasJob = true
-		}
-
-		var port string
-		for k, v := range c.Labels {
-			ln := strutil.SanitizeLabelName(k)
-
-			if strings.HasPrefix(ln, extractScrapePrefix) {
-				switch k {
-				case scrapePort:
-					port = v
-				case scrapeInterval:
-					meta.Labels[model.ScrapeIntervalLabel] = v
-				case scrapeTimeout:
-					meta.Labels[model.ScrapeTimeoutLabel] = v
-				case scrapePath:
-					meta.Labels[model.MetricsPathLabel] = v
-				case scrapeScheme:
-					meta.Labels[model.SchemeLabel] = v
-				case scrapeExternal:
-					meta.ScrapeExternal = strings.ToLower(v) == "true"
-				}
-			} else if strings.HasPrefix(ln, extractLabelPrefix) {
-				meta.Labels[ln[len(extractLabelPrefix):]] = v
-			} else {
-				meta.Labels[dockerLabelContainerLabelPrefix+ln] = v
-			}
+		result = append(result, extractContainer(log, conf, c, networkLabels)...)
+	}
+
+	sortMetas(result)
+	return result
+}
+
+// not exported first, then by name and endpoint
+func sortMetas(xs []Meta) {
+	sort.Slice(xs, func(i, j int) bool {
+		x, y := xs[i], xs[j]
+		if !x.IsExported() && y.IsExported() {
+			return true
+		}
+		if x.IsExported() && !y.IsExported() {
+			return false
+		}
+		if x.Name != y.Name {
+			return x.Name < y.Name
+		}
+		if x.Endpoint != y.Endpoint {
+			return x.Endpoint < y.Endpoint
 		}
+		return !x.IsProbe && y.IsProbe
+	})
+}
 
-		n, found := c.NetworkSettings.Networks[targetNetworkName]
-		if !meta.ScrapeExternal && !found {
-			log.Debug("network not found and no explicit scrape port",
-				"targetNetwork", targetNetworkName,
-				"networks", c.NetworkSettings.Networks)
-			result = append(result, meta)
+// key of the target, unique per container, endpoint and probe
+func targetKey(x Meta) string {
+	key := x.ID + "/" + x.Endpoint
+	if x.IsProbe {
+		key += "/probe"
+	}
+	return key
+}
+
+// scrape settings for a single target of a container
+type endpoint struct {
+	name       string // empty for the default endpoint
+	job        string
+	jobDerived bool // in opt-out mode
+	port       string
+	network    string            // overrides the target networks, when set
+	scrape     map[string]string // scrape labels, e.g. __metrics_path__
+}
+
+// extract 1 Meta per endpoint of the container. Named endpoints inherit the
+// job and scrape settings of the default endpoint, but not the port
+func extractContainer(log *slog.Logger, conf *Config, c types.Container, networkLabels map[string]map[string]string) []Meta {
+	health := containerHealth(c.Status)
+	labels := map[string]string{
+		dockerLabelContainerID:          c.ID,
+		dockerLabelContainerName:        c.Names[0],
+		dockerLabelContainerState:       c.State,
+		dockerLabelContainerHealth:      health,
+		dockerLabelContainerNetworkMode: c.HostConfig.NetworkMode}
+	addComposeLabels(labels, c.Labels)
+
+	keys := conf.labelKeys()
+	var external, optedOut bool
+	var dropped int // by the label filter
+	var p probe
+	var problems []Problem
+	def := endpoint{job: c.Labels[keys.job], scrape: map[string]string{}}
+	if conf.PrometheusIO {
+		applyPrometheusIO(&def, c.Labels, conf.PrometheusIOJob)
+	}
+	named := map[string]*endpoint{}
+	for k, v := range c.Labels {
+		// consumed as scrape settings, rather than passed on as labels
+		if conf.PrometheusIO && strings.HasPrefix(k, prometheusIOPrefix) {
 			continue
 		}
-		log = log.With("networkIP", n.IPAddress)
+		ln := strutil.SanitizeLabelName(k)
 
-		meta.IsInTargetNetwork = true
-
-		// no ports, but scrape port explicitly defined
-		if len(c.Ports) == 0 && port != "" {
-			p, _ := strconv.Atoi(port)
-			c.Ports = append(c.Ports, types.Port{Type: "tcp", PrivatePort: uint16(p)})
-			if n.IPAddress == "" {
-				// insert fake IP to not have Prometheus validation fail, but the actual scrape
-				// this happens when a container continous to restart
-				n.IPAddress = fakeIP
-				log.Info("no ports found or private IP address found, but explicit port specified. Will use explicit port and fake IP", "port", port, "ip", n.IPAddress)
+		if strings.HasPrefix(ln, keys.endpointPrefix) {
+			name, setting, ok := parseEndpointLabel(ln[len(keys.endpointPrefix):])
+			if !ok {
+				log.Debug("ignoring invalid endpoint label", "label", k)
+				continue
+			}
+			e, exists := named[name]
+			if !exists {
+				e = &endpoint{name: name, scrape: map[string]string{}}
+				named[name] = e
+			}
+			switch setting {
+			case endpointJob:
+				e.job = v
+			case endpointPort:
+				e.port = v
+			default:
+				e.scrape[endpointSettings[setting]] = v
+			}
+		} else if strings.HasPrefix(ln, keys.scrapePrefix) {
+			switch k {
+			case keys.scrapePort:
+				def.port = v
+			case keys.scrapeInterval:
+				def.scrape[model.ScrapeIntervalLabel] = v
+			case keys.scrapeTimeout:
+				def.scrape[model.ScrapeTimeoutLabel] = v
+			case keys.scrapePath:
+				def.scrape[model.MetricsPathLabel] = v
+			case keys.scrapeScheme:
+				def.scrape[model.SchemeLabel] = v
+			case keys.scrapeExternal:
+				external = strings.ToLower(v) == "true"
+			case keys.scrapeNetwork:
+				def.network = v
+			default:
+				if !strings.HasPrefix(k, keys.scrapeParam) {
+					break
+				}
+				param, ok := parseParamLabel(keys, k)
+				if !ok {
+					problems = append(problems, newProblem(ProblemInvalidParamName, "scrape param label '%s' ignored, the name is not a valid label name", k))
+					continue
+				}
+				def.scrape[param] = v
+			}
+		} else if strings.HasPrefix(ln, keys.probePrefix) {
+			switch k {
+			case keys.probeModule:
+				p.module = v
+			case keys.probePath:
+				p.path = v
+			case keys.probePort:
+				p.port = v
+			case keys.probeJob:
+				p.job = v
 			}
+		} else if k == keys.scrape {
+			optedOut = strings.ToLower(v) == "false"
+		} else if strings.HasPrefix(ln, keys.prefix) {
+			labels[ln[len(keys.prefix):]] = v
+		} else if conf.LabelFilter.keep(k) {
+			labels[dockerLabelContainerLabelPrefix+ln] = v
+		} else {
+			dropped++
 		}
+	}
 
-		meta.Labels[dockerLabelNetworkIP] = n.IPAddress
+	if conf.OptOut && def.job == "" && !optedOut {
+		def.job, def.jobDerived = derivedJob(conf.JobSource, c), true
+	}
 
-		// match scrape port, fallback to lowest if not defined/found
-		p, found := matchScrapePort(c.Ports, port)
-		if found {
-			meta.HasExplicitPort = true
-		} else {
-			pp, candidates, found := findLowestTCPPrivatePort(c.Ports)
-			if !found {
-				result = append(result, meta)
-				log.Debug("no TCP ports found", "ports", c.Ports)
-				continue
+	// the default endpoint is left out, when only named endpoints are defined
+	endpoints := make([]endpoint, 0, len(named)+1)
+	if (def.job != "" && !def.jobDerived) || len(named) == 0 {
+		endpoints = append(endpoints, def)
+	}
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e := named[name]
+		if e.job == "" {
+			e.job, e.jobDerived = def.job, def.jobDerived
+		}
+		e.network = def.network
+		for k, v := range def.scrape {
+			if _, exists := e.scrape[k]; !exists {
+				e.scrape[k] = v
 			}
-			p = pp
+		}
+		endpoints = append(endpoints, *e)
+	}
 
-			if candidates == 1 || port != "" {
-				meta.HasExplicitPort = true
-			}
+	excluded := conf.ExportPolicy.excluded(c.State, health)
+	if excluded == "" && optedOut {
+		excluded = "opted out by " + keys.scrape + "=false"
+	}
+	if excluded != "" {
+		log.Debug("container excluded by export policy", "reason", excluded)
+	}
+
+	result := make([]Meta, 0, len(endpoints))
+	for _, e := range endpoints {
+		elog := log
+		if e.name != "" {
+			elog = log.With("endpoint", e.name)
+		}
+		meta := extractEndpoint(elog, conf, c, labels, external, e, networkLabels)
+		if meta.JobDerived && (!(meta.IsInTargetNetwork || meta.IsHostNetwork) || !meta.HasTCPPorts) {
+			// in opt-out mode, only containers that can be scraped are targets
+			meta.HasJob, meta.JobDerived = false, false
+			delete(meta.Labels, model.JobLabel)
 		}
-		meta.HasTCPPorts = true
-		meta.Labels[dockerLabelPortPrivate] = strconv.FormatUint(uint64(p.PrivatePort), 10)
+		meta.ExcludedReason = excluded
+		meta.DroppedLabels = dropped
+		meta.Problems = append(append([]Problem{}, problems...), meta.Problems...)
+		for _, problem := range meta.Problems {
+			elog.Info("problem with container labels", "reason", problem.Reason, "problem", problem.Message)
+		}
+		// probed once, on the default endpoint or the first named endpoint
+		if p.module != "" && len(result) == 0 {
+			meta.probe = &p
+		}
+		result = append(result, meta)
+	}
+	return result
+}
+
+func extractEndpoint(log *slog.Logger, conf *Config, c types.Container, containerLabels map[string]string, external bool, e endpoint, networkLabels map[string]map[string]string) Meta {
+	meta := Meta{
+		ID:             c.ID,
+		Name:           c.Names[0],
+		State:          c.State,
+		Endpoint:       e.name,
+		Labels:         make(map[string]string, len(containerLabels)+len(e.scrape)),
+		HasJob:         e.job != "",
+		JobDerived:     e.jobDerived,
+		ScrapeExternal: external}
+
+	for k, v := range containerLabels {
+		meta.Labels[k] = v
+	}
+	for k, v := range e.scrape {
+		meta.Labels[k] = v
+	}
+	if e.job != "" {
+		meta.Labels[model.JobLabel] = e.job
+	}
+	meta.Problems = validateEndpoint(e)
+
+	if container.NetworkMode(c.HostConfig.NetworkMode).IsHost() {
+		return extractHostNetworkEndpoint(log, conf, meta, c.Labels, e)
+	}
 
-		if p.PublicPort > 0 {
-			meta.Labels[dockerLabelPortPublic] = strconv.FormatUint(uint64(p.PublicPort), 10)
-			meta.Labels[dockerLabelPortPublicIP] = p.IP
+	var networkName string
+	var n *network.EndpointSettings
+	var found bool
+	if e.network != "" {
+		networkName = e.network
+		n, found = c.NetworkSettings.Networks[e.network]
+		if !found {
+			meta.HasInvalidScrapeNetwork = true
+			log.Debug("container is not a member of the scrape network",
+				"scrapeNetwork", e.network,
+				"networks", c.NetworkSettings.Networks)
+			return meta
+		}
+	} else {
+		networkName, n, found = conf.TargetNetworks.Select(c.NetworkSettings.Networks)
+	}
+	if !meta.ScrapeExternal && !found {
+		log.Debug("network not found and no explicit scrape port",
+			"targetNetworks", conf.TargetNetworks.String(),
+			"networks", c.NetworkSettings.Networks)
+		return meta
+	}
+	var ip, networkID string
+	if found {
+		ip, networkID = conf.AddressFamily.selectIP(n), n.NetworkID
+		meta.Network = networkName
+		meta.Labels[dockerLabelNetworkName] = networkName
+		if n.GlobalIPv6Address != "" {
+			meta.Labels[dockerLabelNetworkIPv6] = n.GlobalIPv6Address
 		}
+	}
+	log = log.With("network", networkName, "networkIP", ip)
+
+	meta.IsInTargetNetwork = true
 
-		for k, v := range networkLabels[n.NetworkID] {
-			meta.Labels[k] = v
+	// no ports, but valid scrape port explicitly defined. Invalid ports are
+	// reported by validateEndpoint
+	ports := c.Ports
+	port := e.port
+	validPort, isValidPort := parsePort(port)
+	if len(ports) == 0 && isValidPort {
+		ports = []types.Port{{Type: "tcp", PrivatePort: validPort}}
+	}
+
+	meta.Labels[dockerLabelNetworkIP] = ip
+
+	// match scrape port, fallback to lowest if not defined/found
+	p, found := matchScrapePort(ports, port)
+	if found {
+		meta.HasExplicitPort = true
+	} else {
+		if isValidPort && len(c.Ports) > 0 {
+			meta.Problems = append(meta.Problems, newProblem(ProblemPortNotExposed, "scrape port %s is not among the exposed ports %s", port, formatPorts(c.Ports)))
+		}
+		pp, candidates, found := findLowestTCPPrivatePort(ports)
+		if !found {
+			log.Debug("no TCP ports found", "ports", ports)
+			return meta
 		}
+		p = pp
 
-		if port == "" {
-			port = strconv.FormatUint(uint64(p.PrivatePort), 10)
+		if candidates == 1 || port != "" {
+			meta.HasExplicitPort = true
 		}
+	}
+	meta.HasTCPPorts = true
+	meta.Labels[dockerLabelPortPrivate] = strconv.FormatUint(uint64(p.PrivatePort), 10)
 
-		if meta.ScrapeExternal {
-			meta.Address = net.JoinHostPort(externalHost, port)
-		} else {
-			meta.Address = net.JoinHostPort(n.IPAddress, port)
+	if p.PublicPort > 0 {
+		meta.Labels[dockerLabelPortPublic] = strconv.FormatUint(uint64(p.PublicPort), 10)
+		meta.Labels[dockerLabelPortPublicIP] = p.IP
+	}
+
+	for k, v := range networkLabels[networkID] {
+		meta.Labels[k] = v
+	}
+
+	if port == "" {
+		port = strconv.FormatUint(uint64(p.PrivatePort), 10)
+	}
+
+	data := newTemplateData(conf, meta, c.Labels, port, ip)
+	inst, err := instance(conf, meta, data)
+	if err != nil {
+		log.Info("failed to render instance", "error", err)
+		meta.TemplateError = err.Error()
+		return meta
+	}
+	meta.Labels[model.InstanceLabel] = inst
+
+	if meta.ScrapeExternal {
+		meta.Address, err = externalAddress(conf, data)
+		if err != nil {
+			log.Info("failed to render external address", "error", err)
+			meta.TemplateError = err.Error()
+			return meta
+		}
+	} else if ip != "" {
+		meta.Address = net.JoinHostPort(ip, port)
+	} else if n.IPAddress != "" || n.GlobalIPv6Address != "" {
+		// not restarting, but never reachable with the address family
+		meta.NoAddressInFamily = true
+		log.Info("no IP address of the address family", "addressFamily", conf.AddressFamily)
+		return meta
+	} else {
+		// this happens when a container continues to restart or has exited
+		meta.NoIPAddress = true
+		switch conf.NoAddressPolicy {
+		case NoAddressDrop, NoAddressLastKnown:
+			// the last known address is resolved by the Discovery
+			log.Debug("no IP address found", "port", port, "policy", conf.NoAddressPolicy)
+			return meta
+		default:
+			meta.Address = net.JoinHostPort(noAddressHost, port)
+			log.Info("no IP address found. Will use marker address, that fails to scrape", "address", meta.Address)
 		}
-		meta.Labels[model.AddressLabel] = meta.Address
-		meta.Labels[model.InstanceLabel] = instancePrefix + meta.Name + ":" + port
+	}
+	meta.Labels[model.AddressLabel] = meta.Address
 
-		result = append(result, meta)
+	return meta
+}
+
+// containers with network_mode host are reachable on the host address, but
+// the exposed ports are not known, so the scrape port must be explicit
+func extractHostNetworkEndpoint(log *slog.Logger, conf *Config, meta Meta, containerLabels map[string]string, e endpoint) Meta {
+	meta.IsHostNetwork = true
+	if e.port == "" {
+		log.Debug("host network container without explicit scrape port")
+		return meta
 	}
+	meta.HasTCPPorts = true
+	meta.HasExplicitPort = true
+	meta.Labels[dockerLabelPortPrivate] = e.port
 
-	sort.Slice(result, func(i, j int) bool {
-		x, y := result[i], result[j]
-		if !x.IsExported() && y.IsExported() {
-			return true
+	data := newTemplateData(conf, meta, containerLabels, e.port, "")
+	inst, err := instance(conf, meta, data)
+	if err != nil {
+		log.Info("failed to render instance", "error", err)
+		meta.TemplateError = err.Error()
+		return meta
+	}
+
+	if meta.ScrapeExternal {
+		meta.Address, err = externalAddress(conf, data)
+		if err != nil {
+			log.Info("failed to render external address", "error", err)
+			meta.TemplateError = err.Error()
+			return meta
 		}
-		if x.IsExported() && !y.IsExported() {
-			return false
+	} else {
+		host := conf.HostNetworkAddress
+		if host == "" {
+			host = conf.ExternalHost
 		}
-		return x.Name < y.Name
-	})
+		meta.Address = net.JoinHostPort(host, e.port)
+	}
+	meta.Labels[model.AddressLabel] = meta.Address
+	meta.Labels[model.InstanceLabel] = inst
+	return meta
+}
 
-	return result
+// instance label from the template, or <instance prefix><container name>[/<endpoint>]:<port>
+func instance(conf *Config, meta Meta, data TemplateData) (string, error) {
+	if conf.InstanceTemplate != nil {
+		return render(conf.InstanceTemplate, data)
+	}
+	name := meta.Name
+	if conf.InstanceNaming == InstanceByCompose {
+		if n, ok := composeName(meta.Labels); ok {
+			name = n
+		}
+	}
+	result := conf.InstancePrefix + name
+	if meta.Endpoint != "" {
+		result += "/" + meta.Endpoint
+	}
+	return result + ":" + data.Port, nil
+}
+
+// parse sanitized label <prefix>endpoint_<name>_<setting>, without the prefix
+func parseEndpointLabel(rest string) (string, string, bool) {
+	i := strings.LastIndex(rest, "_")
+	if i <= 0 {
+		return "", "", false
+	}
+	name, setting := rest[:i], rest[i+1:]
+	if setting != endpointJob && setting != endpointPort {
+		if _, exists := endpointSettings[setting]; !exists {
+			return "", "", false
+		}
+	}
+	return name, setting, true
 }
 
 func matchScrapePort(xs []types.Port, scrapePort string) (types.Port, bool) {
@@ -1,10 +1,16 @@
 package docker
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/network"
@@ -15,6 +21,14 @@ import (
 func TestExtractSingleContainer(t *testing.T) {
 	instancePrefix := "host1"
 	targetNetwork := "metrics-net"
+	conf := &Config{
+		InstancePrefix: instancePrefix,
+		ExternalHost:   instancePrefix,
+		TargetNetworks: TargetNetworks{Names: []string{targetNetwork}}}
+	externalConf := &Config{
+		InstancePrefix: instancePrefix,
+		ExternalHost:   "server1",
+		TargetNetworks: conf.TargetNetworks}
 
 	log := slog.Default()
 
@@ -29,7 +43,7 @@ func TestExtractSingleContainer(t *testing.T) {
 				Networks: map[string]*network.EndpointSettings{
 					targetNetwork: {IPAddress: "ip1"}}}}
 
-		xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+		xs := extract(log, conf, []types.Container{c}, nil)
 
 		Convey("should have 1 entry", func() {
 			So(xs, ShouldHaveLength, 1)
@@ -68,11 +82,11 @@ func TestExtractSingleContainer(t *testing.T) {
 			})
 		})
 
-		Convey("with label "+scrapePort, func() {
+		Convey("with label "+defaultKeys.scrapePort, func() {
 			Convey("2001", func() {
-				c.Labels[scrapePort] = "2001"
+				c.Labels[defaultKeys.scrapePort] = "2001"
 
-				xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+				xs := extract(log, conf, []types.Container{c}, nil)
 				x := xs[0]
 
 				Convey("should have target with port 2001", func() {
@@ -84,17 +98,17 @@ func TestExtractSingleContainer(t *testing.T) {
 					So(x.Labels[model.InstanceLabel], ShouldEqual, "host1/containerName:2001")
 				})
 
-				Convey("should not have any labels with prefix "+dockerLabelContainerLabelPrefix+extractScrapePrefix, func() {
-					So(x.Labels, ShouldNotHaveKeyWithPrefix, dockerLabelContainerLabelPrefix+extractScrapePrefix)
+				Convey("should not have any labels with prefix "+dockerLabelContainerLabelPrefix+defaultKeys.scrapePrefix, func() {
+					So(x.Labels, ShouldNotHaveKeyWithPrefix, dockerLabelContainerLabelPrefix+defaultKeys.scrapePrefix)
 				})
 			})
 		})
 
-		Convey("with label "+scrapeInterval, func() {
+		Convey("with label "+defaultKeys.scrapeInterval, func() {
 			Convey("5s", func() {
-				c.Labels[scrapeInterval] = "5s"
+				c.Labels[defaultKeys.scrapeInterval] = "5s"
 
-				xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+				xs := extract(log, conf, []types.Container{c}, nil)
 				x := xs[0]
 
 				Convey("should have label "+model.ScrapeIntervalLabel, func() {
@@ -102,17 +116,17 @@ func TestExtractSingleContainer(t *testing.T) {
 					So(x.Labels[model.ScrapeIntervalLabel], ShouldEqual, "5s")
 				})
 
-				Convey("should not have any labels with prefix "+dockerLabelContainerLabelPrefix+extractScrapePrefix, func() {
-					So(x.Labels, ShouldNotHaveKeyWithPrefix, dockerLabelContainerLabelPrefix+extractScrapePrefix)
+				Convey("should not have any labels with prefix "+dockerLabelContainerLabelPrefix+defaultKeys.scrapePrefix, func() {
+					So(x.Labels, ShouldNotHaveKeyWithPrefix, dockerLabelContainerLabelPrefix+defaultKeys.scrapePrefix)
 				})
 			})
 		})
 
-		Convey("with label "+scrapeTimeout, func() {
+		Convey("with label "+defaultKeys.scrapeTimeout, func() {
 			Convey("10s", func() {
-				c.Labels[scrapeTimeout] = "10s"
+				c.Labels[defaultKeys.scrapeTimeout] = "10s"
 
-				xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+				xs := extract(log, conf, []types.Container{c}, nil)
 				x := xs[0]
 
 				Convey("should have label "+model.ScrapeTimeoutLabel, func() {
@@ -120,17 +134,17 @@ func TestExtractSingleContainer(t *testing.T) {
 					So(x.Labels[model.ScrapeTimeoutLabel], ShouldEqual, "10s")
 				})
 
-				Convey("should not have any labels with prefix "+dockerLabelContainerLabelPrefix+extractScrapePrefix, func() {
-					So(x.Labels, ShouldNotHaveKeyWithPrefix, dockerLabelContainerLabelPrefix+extractScrapePrefix)
+				Convey("should not have any labels with prefix "+dockerLabelContainerLabelPrefix+defaultKeys.scrapePrefix, func() {
+					So(x.Labels, ShouldNotHaveKeyWithPrefix, dockerLabelContainerLabelPrefix+defaultKeys.scrapePrefix)
 				})
 			})
 		})
 
-		Convey("with label "+scrapePath, func() {
+		Convey("with label "+defaultKeys.scrapePath, func() {
 			Convey("10s", func() {
-				c.Labels[scrapePath] = "/stuff/metrics"
+				c.Labels[defaultKeys.scrapePath] = "/stuff/metrics"
 
-				xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+				xs := extract(log, conf, []types.Container{c}, nil)
 				x := xs[0]
 
 				Convey("should have label "+model.MetricsPathLabel, func() {
@@ -138,8 +152,8 @@ func TestExtractSingleContainer(t *testing.T) {
 					So(x.Labels[model.MetricsPathLabel], ShouldEqual, "/stuff/metrics")
 				})
 
-				Convey("should not have any labels with prefix "+dockerLabelContainerLabelPrefix+extractScrapePrefix, func() {
-					So(x.Labels, ShouldNotHaveKeyWithPrefix, dockerLabelContainerLabelPrefix+extractScrapePrefix)
+				Convey("should not have any labels with prefix "+dockerLabelContainerLabelPrefix+defaultKeys.scrapePrefix, func() {
+					So(x.Labels, ShouldNotHaveKeyWithPrefix, dockerLabelContainerLabelPrefix+defaultKeys.scrapePrefix)
 				})
 			})
 		})
@@ -148,7 +162,7 @@ func TestExtractSingleContainer(t *testing.T) {
 		Convey("with label "+key+"and value 'val1'", func() {
 			c.Labels[key] = "val1"
 
-			xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+			xs := extract(log, conf, []types.Container{c}, nil)
 			x := xs[0]
 
 			Convey("should have label key1", func() {
@@ -165,7 +179,7 @@ func TestExtractSingleContainer(t *testing.T) {
 		Convey("with label "+key+"and value 'val1'", func() {
 			c.Labels[key] = "val1"
 
-			xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+			xs := extract(log, conf, []types.Container{c}, nil)
 			x := xs[0]
 
 			Convey("should have sanitized label key _5b", func() {
@@ -181,7 +195,7 @@ func TestExtractSingleContainer(t *testing.T) {
 		Convey("with extra port", func() {
 			Convey("2002, should still have target on 2000", func() {
 				c.Ports = append(c.Ports, types.Port{PrivatePort: 2002, Type: "tcp"})
-				xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+				xs := extract(log, conf, []types.Container{c}, nil)
 
 				Convey("should have 1 entry", func() {
 					So(xs, ShouldHaveLength, 1)
@@ -199,7 +213,7 @@ func TestExtractSingleContainer(t *testing.T) {
 
 			Convey("1000, should change target port", func() {
 				c.Ports = append(c.Ports, types.Port{PrivatePort: 1000, Type: "tcp"})
-				xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+				xs := extract(log, conf, []types.Container{c}, nil)
 
 				Convey("should have 1 entry", func() {
 					So(xs, ShouldHaveLength, 1)
@@ -214,10 +228,10 @@ func TestExtractSingleContainer(t *testing.T) {
 					})
 				})
 
-				Convey("with label "+scrapePort, func() {
-					c.Labels[scrapePort] = "1998"
+				Convey("with label "+defaultKeys.scrapePort, func() {
+					c.Labels[defaultKeys.scrapePort] = "1998"
 
-					xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+					xs := extract(log, conf, []types.Container{c}, nil)
 
 					Convey("should have 1 entry", func() {
 						So(xs, ShouldHaveLength, 1)
@@ -238,7 +252,7 @@ func TestExtractSingleContainer(t *testing.T) {
 		Convey("with duplicate port", func() {
 			c.Ports = append(c.Ports, types.Port{PrivatePort: 2000, Type: "tcp"})
 
-			xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+			xs := extract(log, conf, []types.Container{c}, nil)
 
 			Convey("should have 1 entry", func() {
 				So(xs, ShouldHaveLength, 1)
@@ -259,7 +273,7 @@ func TestExtractSingleContainer(t *testing.T) {
 				Networks: map[string]*network.EndpointSettings{
 					"other": {IPAddress: "ip1"}}}
 
-			xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+			xs := extract(log, conf, []types.Container{c}, nil)
 
 			Convey("should have 1 entry", func() {
 				So(xs, ShouldHaveLength, 1)
@@ -275,7 +289,7 @@ func TestExtractSingleContainer(t *testing.T) {
 		Convey("no ports", func() {
 			c.Ports = nil
 
-			xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+			xs := extract(log, conf, []types.Container{c}, nil)
 
 			Convey("should have 1 entry", func() {
 				So(xs, ShouldHaveLength, 1)
@@ -291,7 +305,7 @@ func TestExtractSingleContainer(t *testing.T) {
 		Convey("not a tcp port", func() {
 			c.Ports[0].Type = "udp"
 
-			xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+			xs := extract(log, conf, []types.Container{c}, nil)
 
 			Convey("should have 1 entry", func() {
 				So(xs, ShouldHaveLength, 1)
@@ -304,9 +318,9 @@ func TestExtractSingleContainer(t *testing.T) {
 			})
 		})
 
-		Convey("no "+jobLabelPrefix, func() {
-			delete(c.Labels, jobLabelPrefix)
-			xs := extract(log, instancePrefix, instancePrefix, targetNetwork, []types.Container{c}, nil)
+		Convey("no "+defaultKeys.job, func() {
+			delete(c.Labels, defaultKeys.job)
+			xs := extract(log, conf, []types.Container{c}, nil)
 
 			Convey("should have 1 entry", func() {
 				So(xs, ShouldHaveLength, 1)
@@ -318,9 +332,9 @@ func TestExtractSingleContainer(t *testing.T) {
 				})
 			})
 		})
-		Convey("with "+scrapeExternal+"=true", func() {
-			c.Labels[scrapeExternal] = "true"
-			xs := extract(log, instancePrefix, "server1", targetNetwork, []types.Container{c}, nil)
+		Convey("with "+defaultKeys.scrapeExternal+"=true", func() {
+			c.Labels[defaultKeys.scrapeExternal] = "true"
+			xs := extract(log, externalConf, []types.Container{c}, nil)
 
 			Convey("should have 1 entry", func() {
 				So(xs, ShouldHaveLength, 1)
@@ -333,9 +347,9 @@ func TestExtractSingleContainer(t *testing.T) {
 				})
 			})
 
-			Convey("with "+scrapeScheme+"=https", func() {
-				c.Labels[scrapeScheme] = "https"
-				xs := extract(log, instancePrefix, "server1", targetNetwork, []types.Container{c}, nil)
+			Convey("with "+defaultKeys.scrapeScheme+"=https", func() {
+				c.Labels[defaultKeys.scrapeScheme] = "https"
+				xs := extract(log, externalConf, []types.Container{c}, nil)
 
 				Convey("should have 1 entry", func() {
 					So(xs, ShouldHaveLength, 1)
@@ -348,9 +362,9 @@ func TestExtractSingleContainer(t *testing.T) {
 					})
 				})
 			})
-			Convey("with "+scrapeScheme+"=http", func() {
-				c.Labels[scrapeScheme] = "http"
-				xs := extract(log, instancePrefix, "server1", targetNetwork, []types.Container{c}, nil)
+			Convey("with "+defaultKeys.scrapeScheme+"=http", func() {
+				c.Labels[defaultKeys.scrapeScheme] = "http"
+				xs := extract(log, externalConf, []types.Container{c}, nil)
 
 				Convey("should have 1 entry", func() {
 					So(xs, ShouldHaveLength, 1)
@@ -369,6 +383,9 @@ func TestExtractSingleContainer(t *testing.T) {
 
 // actual map[string]string
 // expected string
+// label keys of the default prefix
+var defaultKeys = newLabelKeys(DefaultLabelPrefix)
+
 func ShouldNotHaveKeyWithPrefix(actual interface{}, expected ...interface{}) string {
 	xs, ok := actual.(map[string]string)
 	if !ok {
@@ -399,3 +416,1416 @@ func ShouldNotHaveKeyWithPrefix(actual interface{}, expected ...interface{}) str
 	}
 	return ""
 }
+
+func TestExtractNamedEndpoints(t *testing.T) {
+	instancePrefix := "host1"
+	targetNetwork := "metrics-net"
+	conf := &Config{
+		InstancePrefix: instancePrefix,
+		ExternalHost:   instancePrefix,
+		TargetNetworks: TargetNetworks{Names: []string{targetNetwork}}}
+
+	log := slog.Default()
+
+	Convey("given container with prometheus_job label, a named endpoint 'jmx' and 2 exposed ports", t, func() {
+		c := types.Container{
+			ID:    "containerID",
+			Names: []string{"/containerName"},
+			Labels: map[string]string{
+				"prometheus_job":                "job1",
+				defaultKeys.scrapePath:          "/app/metrics",
+				defaultKeys.scrapeInterval:      "5s",
+				defaultKeys.scrapePort:          "2000",
+				"prometheus_endpoint_jmx_port":  "9404",
+				"prometheus_endpoint_jmx_job":   "jmx",
+				"prometheus_endpoint_jmx_path":  "/metrics",
+				"prometheus_endpoint_jmx_other": "x"},
+			Ports: []types.Port{{Type: "tcp", PrivatePort: 2000}, {Type: "tcp", PrivatePort: 9404}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					targetNetwork: {IPAddress: "ip1"}}}}
+
+		xs := extract(log, conf, []types.Container{c}, nil)
+
+		Convey("should have 2 entries", func() {
+			So(xs, ShouldHaveLength, 2)
+
+			x, y := xs[0], xs[1]
+			Convey("default endpoint should be unchanged", func() {
+				So(x.Endpoint, ShouldEqual, "")
+				So(x.Address, ShouldEqual, "ip1:2000")
+				So(x.Labels[model.JobLabel], ShouldEqual, "job1")
+				So(x.Labels[model.InstanceLabel], ShouldEqual, "host1/containerName:2000")
+				So(x.Labels[model.MetricsPathLabel], ShouldEqual, "/app/metrics")
+				So(x.IsExported(), ShouldBeTrue)
+			})
+
+			Convey("named endpoint should have own job, port, instance and path", func() {
+				So(y.Endpoint, ShouldEqual, "jmx")
+				So(y.ID, ShouldEqual, "containerID")
+				So(y.Address, ShouldEqual, "ip1:9404")
+				So(y.Labels[model.JobLabel], ShouldEqual, "jmx")
+				So(y.Labels[model.InstanceLabel], ShouldEqual, "host1/containerName/jmx:9404")
+				So(y.Labels[model.MetricsPathLabel], ShouldEqual, "/metrics")
+				So(y.HasExplicitPort, ShouldBeTrue)
+				So(y.IsExported(), ShouldBeTrue)
+			})
+
+			Convey("named endpoint should inherit scrape interval", func() {
+				So(y.Labels[model.ScrapeIntervalLabel], ShouldEqual, "5s")
+			})
+
+			Convey("should not have any labels with prefix endpoint_", func() {
+				So(x.Labels, ShouldNotHaveKeyWithPrefix, "endpoint_")
+				So(y.Labels, ShouldNotHaveKeyWithPrefix, "endpoint_")
+			})
+		})
+
+		Convey("without "+defaultKeys.job, func() {
+			delete(c.Labels, defaultKeys.job)
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should only have the named endpoint", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].Endpoint, ShouldEqual, "jmx")
+				So(xs[0].HasJob, ShouldBeTrue)
+			})
+		})
+
+		Convey("named endpoint without job", func() {
+			delete(c.Labels, "prometheus_endpoint_jmx_job")
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should inherit "+defaultKeys.job, func() {
+				So(xs, ShouldHaveLength, 2)
+				So(xs[1].Labels[model.JobLabel], ShouldEqual, "job1")
+			})
+		})
+
+		Convey("named endpoint without port", func() {
+			delete(c.Labels, "prometheus_endpoint_jmx_port")
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should not export the named endpoint, with problem "+string(ProblemMissingEndpointPort), func() {
+				So(xs, ShouldHaveLength, 2)
+				x, y := xs[1], xs[0]
+				So(x.IsExported(), ShouldBeTrue)
+				So(y.Endpoint, ShouldEqual, "jmx")
+				So(y.IsExported(), ShouldBeFalse)
+				So(y.Reason(), ShouldEqual, string(ProblemMissingEndpointPort))
+			})
+		})
+	})
+}
+
+func TestExtractTargetNetworks(t *testing.T) {
+	instancePrefix := "host1"
+	log := slog.Default()
+
+	Convey("given container with prometheus_job label, in networks team-b, team-a and metrics-net", t, func() {
+		c := types.Container{
+			ID:     "containerID",
+			Names:  []string{"/containerName"},
+			Labels: map[string]string{"prometheus_job": "job1"},
+			Ports:  []types.Port{{Type: "tcp", PrivatePort: 2000}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"team-b":      {IPAddress: "ipB"},
+					"team-a":      {IPAddress: "ipA"},
+					"metrics-net": {IPAddress: "ipM"}}}}
+
+		Convey("with target networks 'other,metrics-net'", func() {
+			targetNetworks, err := NewTargetNetworks([]string{"other", "metrics-net"}, "")
+			So(err, ShouldBeNil)
+			conf := &Config{InstancePrefix: instancePrefix, TargetNetworks: targetNetworks}
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should use metrics-net", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].Address, ShouldEqual, "ipM:2000")
+				So(xs[0].Network, ShouldEqual, "metrics-net")
+				So(xs[0].Labels[dockerLabelNetworkName], ShouldEqual, "metrics-net")
+			})
+		})
+
+		Convey("with target network pattern 'team-.*'", func() {
+			targetNetworks, err := NewTargetNetworks(nil, "team-.*")
+			So(err, ShouldBeNil)
+			conf := &Config{InstancePrefix: instancePrefix, TargetNetworks: targetNetworks}
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should use the first matching network in alphabetical order", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].Address, ShouldEqual, "ipA:2000")
+				So(xs[0].Labels[dockerLabelNetworkName], ShouldEqual, "team-a")
+			})
+		})
+
+		Convey("with target network 'team-b' and pattern 'team-.*'", func() {
+			targetNetworks, err := NewTargetNetworks([]string{"team-b"}, "team-.*")
+			So(err, ShouldBeNil)
+
+			Convey("should have string representation", func() {
+				So(targetNetworks.String(), ShouldEqual, "team-b,~team-.*")
+			})
+
+			conf := &Config{InstancePrefix: instancePrefix, TargetNetworks: targetNetworks}
+			xs := extract(log, conf, []types.Container{c}, nil)
+			Convey("names should take precedence over the pattern", func() {
+				So(xs[0].Address, ShouldEqual, "ipB:2000")
+			})
+		})
+
+		Convey("with target network pattern 'team' (anchored)", func() {
+			targetNetworks, err := NewTargetNetworks(nil, "team")
+			So(err, ShouldBeNil)
+			conf := &Config{InstancePrefix: instancePrefix, TargetNetworks: targetNetworks}
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should not be in target network", func() {
+				So(xs[0].IsInTargetNetwork, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestExtractScrapeNetwork(t *testing.T) {
+	conf := &Config{
+		InstancePrefix: "host1",
+		TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+	log := slog.Default()
+
+	Convey("given container with prometheus_job label, in networks app-net and metrics-net", t, func() {
+		c := types.Container{
+			ID:     "containerID",
+			Names:  []string{"/containerName"},
+			Labels: map[string]string{"prometheus_job": "job1"},
+			Ports:  []types.Port{{Type: "tcp", PrivatePort: 2000}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"app-net":     {IPAddress: "ipApp"},
+					"metrics-net": {IPAddress: "ipM"}}}}
+
+		Convey("with label "+defaultKeys.scrapeNetwork+"=app-net", func() {
+			c.Labels[defaultKeys.scrapeNetwork] = "app-net"
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should use app-net, overriding the target network", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].Address, ShouldEqual, "ipApp:2000")
+				So(xs[0].Labels[dockerLabelNetworkName], ShouldEqual, "app-net")
+				So(xs[0].IsExported(), ShouldBeTrue)
+			})
+
+			Convey("should not have any labels with prefix "+dockerLabelContainerLabelPrefix+defaultKeys.scrapePrefix, func() {
+				So(xs[0].Labels, ShouldNotHaveKeyWithPrefix, dockerLabelContainerLabelPrefix+defaultKeys.scrapePrefix)
+			})
+		})
+
+		Convey("with label "+defaultKeys.scrapeNetwork+"=other", func() {
+			c.Labels[defaultKeys.scrapeNetwork] = "other"
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should have invalid scrape network and not be exported", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].HasInvalidScrapeNetwork, ShouldBeTrue)
+				So(xs[0].IsInTargetNetwork, ShouldBeFalse)
+				So(xs[0].IsExported(), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestExtractAddressFamily(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given container with prometheus_job label and 1 exposed port", t, func() {
+		c := types.Container{
+			ID:     "containerID",
+			Names:  []string{"/containerName"},
+			Labels: map[string]string{"prometheus_job": "job1"},
+			Ports:  []types.Port{{Type: "tcp", PrivatePort: 2000}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+		Convey("in dual-stack target network", func() {
+			c.NetworkSettings = &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "10.0.0.2", GlobalIPv6Address: "fd00::2"}}}
+
+			Convey("with address family ipv4", func() {
+				conf.AddressFamily = IPv4
+				xs := extract(log, conf, []types.Container{c}, nil)
+
+				Convey("should have IPv4 address", func() {
+					So(xs[0].Address, ShouldEqual, "10.0.0.2:2000")
+				})
+
+				Convey("should have label "+dockerLabelNetworkIPv6, func() {
+					So(xs[0].Labels[dockerLabelNetworkIPv6], ShouldEqual, "fd00::2")
+				})
+			})
+
+			Convey("with default address family", func() {
+				xs := extract(log, conf, []types.Container{c}, nil)
+
+				Convey("should have IPv4 address", func() {
+					So(xs[0].Address, ShouldEqual, "10.0.0.2:2000")
+				})
+			})
+
+			Convey("with address family ipv6", func() {
+				conf.AddressFamily = IPv6
+				xs := extract(log, conf, []types.Container{c}, nil)
+
+				Convey("should have bracketed IPv6 address", func() {
+					So(xs[0].Address, ShouldEqual, "[fd00::2]:2000")
+					So(xs[0].Labels[model.AddressLabel], ShouldEqual, "[fd00::2]:2000")
+					So(xs[0].IsExported(), ShouldBeTrue)
+				})
+			})
+
+			Convey("with address family prefer-ipv6", func() {
+				conf.AddressFamily = PreferIPv6
+				xs := extract(log, conf, []types.Container{c}, nil)
+
+				Convey("should have bracketed IPv6 address", func() {
+					So(xs[0].Address, ShouldEqual, "[fd00::2]:2000")
+				})
+			})
+		})
+
+		Convey("in IPv6-only target network", func() {
+			c.NetworkSettings = &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {GlobalIPv6Address: "fd00::3"}}}
+
+			Convey("with address family ipv6", func() {
+				conf.AddressFamily = IPv6
+				xs := extract(log, conf, []types.Container{c}, nil)
+
+				Convey("should have bracketed IPv6 address", func() {
+					So(xs[0].Address, ShouldEqual, "[fd00::3]:2000")
+					So(xs[0].Labels[dockerLabelNetworkIPv6], ShouldEqual, "fd00::3")
+				})
+			})
+
+			Convey("with address family prefer-ipv6", func() {
+				conf.AddressFamily = PreferIPv6
+				xs := extract(log, conf, []types.Container{c}, nil)
+
+				Convey("should have bracketed IPv6 address", func() {
+					So(xs[0].Address, ShouldEqual, "[fd00::3]:2000")
+				})
+			})
+
+			Convey("with address family ipv4", func() {
+				conf.AddressFamily = IPv4
+				xs := extract(log, conf, []types.Container{c}, nil)
+
+				Convey("should not be exported, with reason no_address_in_family", func() {
+					So(xs[0].Address, ShouldBeEmpty)
+					So(xs[0].IsExported(), ShouldBeFalse)
+					So(xs[0].NoAddressInFamily, ShouldBeTrue)
+					So(xs[0].NoIPAddress, ShouldBeFalse)
+					So(xs[0].Reason(), ShouldEqual, "no_address_in_family")
+				})
+			})
+		})
+
+		Convey("in IPv4-only target network, with address family prefer-ipv6", func() {
+			c.NetworkSettings = &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "10.0.0.4"}}}
+			conf.AddressFamily = PreferIPv6
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should fall back to IPv4 address", func() {
+				So(xs[0].Address, ShouldEqual, "10.0.0.4:2000")
+				So(xs[0].Labels, ShouldNotContainKey, dockerLabelNetworkIPv6)
+			})
+		})
+	})
+}
+
+func TestExtractHostNetwork(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given container with prometheus_job label and network_mode host", t, func() {
+		c := types.Container{
+			ID:     "containerID",
+			Names:  []string{"/containerName"},
+			Labels: map[string]string{"prometheus_job": "job1"},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"host": {}}}}
+		c.HostConfig.NetworkMode = "host"
+		conf := &Config{
+			InstancePrefix:     "host1",
+			ExternalHost:       "server1",
+			HostNetworkAddress: "10.1.1.1",
+			TargetNetworks:     TargetNetworks{Names: []string{"metrics-net"}}}
+
+		Convey("without "+defaultKeys.scrapePort, func() {
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should be host network, but not exported", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].IsHostNetwork, ShouldBeTrue)
+				So(xs[0].HasTCPPorts, ShouldBeFalse)
+				So(xs[0].IsExported(), ShouldBeFalse)
+			})
+		})
+
+		Convey("with "+defaultKeys.scrapePort+"=9100", func() {
+			c.Labels[defaultKeys.scrapePort] = "9100"
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should be exported on the host network address", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].IsHostNetwork, ShouldBeTrue)
+				So(xs[0].IsExported(), ShouldBeTrue)
+				So(xs[0].Address, ShouldEqual, "10.1.1.1:9100")
+				So(xs[0].Labels[model.InstanceLabel], ShouldEqual, "host1/containerName:9100")
+			})
+
+			Convey("without host network address", func() {
+				conf.HostNetworkAddress = ""
+				xs := extract(log, conf, []types.Container{c}, nil)
+
+				Convey("should fall back to external host", func() {
+					So(xs[0].Address, ShouldEqual, "server1:9100")
+				})
+			})
+		})
+	})
+}
+
+func TestExtractExportPolicy(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given container with prometheus_job label, in target network and with 1 exposed port", t, func() {
+		c := types.Container{
+			ID:     "containerID",
+			Names:  []string{"/containerName"},
+			Labels: map[string]string{"prometheus_job": "job1"},
+			State:  "running",
+			Status: "Up 5 minutes (unhealthy)",
+			Ports:  []types.Port{{Type: "tcp", PrivatePort: 2000}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "ip1"}}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+		Convey("without export policy", func() {
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should be exported", func() {
+				So(xs[0].IsExported(), ShouldBeTrue)
+			})
+
+			Convey("should have label "+dockerLabelContainerHealth, func() {
+				So(xs[0].Labels[dockerLabelContainerHealth], ShouldEqual, HealthUnhealthy)
+			})
+		})
+
+		Convey("excluding health unhealthy", func() {
+			conf.ExportPolicy = ExportPolicy{ExcludeHealth: []string{HealthUnhealthy}}
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should be excluded with reason", func() {
+				So(xs[0].IsExported(), ShouldBeFalse)
+				So(xs[0].ExcludedReason, ShouldEqual, "health unhealthy")
+			})
+		})
+
+		Convey("excluding state exited", func() {
+			conf.ExportPolicy = ExportPolicy{ExcludeStates: []string{"exited"}}
+
+			Convey("should be exported, when running", func() {
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs[0].IsExported(), ShouldBeTrue)
+			})
+
+			Convey("should be excluded with reason, when exited", func() {
+				c.State = "exited"
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs[0].IsExported(), ShouldBeFalse)
+				So(xs[0].ExcludedReason, ShouldEqual, "container state exited")
+			})
+		})
+	})
+}
+
+func TestContainerHealth(t *testing.T) {
+	Convey("health should be parsed from the container status", t, func() {
+		So(containerHealth("Up 5 minutes (healthy)"), ShouldEqual, HealthHealthy)
+		So(containerHealth("Up 5 minutes (unhealthy)"), ShouldEqual, HealthUnhealthy)
+		So(containerHealth("Up 2 seconds (health: starting)"), ShouldEqual, HealthStarting)
+		So(containerHealth("Up 5 minutes"), ShouldEqual, HealthNone)
+		So(containerHealth("Exited (0) 2 hours ago"), ShouldEqual, HealthNone)
+	})
+}
+
+func TestExportPolicyValidate(t *testing.T) {
+	Convey("export policy validation", t, func() {
+		So(ExportPolicy{}.Validate(), ShouldBeNil)
+		So(ExportPolicy{ExcludeStates: []string{StateExited, StatePaused, StateRestarting}}.Validate(), ShouldBeNil)
+		So(ExportPolicy{ExcludeHealth: []string{HealthUnhealthy, HealthNone}}.Validate(), ShouldBeNil)
+		So(ExportPolicy{ExcludeStates: []string{"stopped"}}.Validate(), ShouldNotBeNil)
+		So(ExportPolicy{ExcludeStates: []string{"Exited"}}.Validate(), ShouldNotBeNil)
+		So(ExportPolicy{ExcludeHealth: []string{"sick"}}.Validate(), ShouldNotBeNil)
+	})
+}
+
+func TestExtractNoIPAddress(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given restarting container with prometheus_job and "+defaultKeys.scrapePort+" labels, in target network without IP address and no ports", t, func() {
+		c := types.Container{
+			ID:    "containerID",
+			Names: []string{"/containerName"},
+			Labels: map[string]string{
+				"prometheus_job":       "job1",
+				defaultKeys.scrapePort: "2000"},
+			State: "restarting",
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {}}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+		Convey("with default policy", func() {
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should be exported with marker address", func() {
+				So(xs[0].NoIPAddress, ShouldBeTrue)
+				So(xs[0].IsExported(), ShouldBeTrue)
+				So(xs[0].Address, ShouldEqual, "no-address.invalid:2000")
+				So(xs[0].Labels[model.InstanceLabel], ShouldEqual, "host1/containerName:2000")
+			})
+		})
+
+		Convey("with policy "+string(NoAddressDrop), func() {
+			conf.NoAddressPolicy = NoAddressDrop
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should not be exported", func() {
+				So(xs[0].NoIPAddress, ShouldBeTrue)
+				So(xs[0].IsExported(), ShouldBeFalse)
+				So(xs[0].Labels, ShouldNotContainKey, model.AddressLabel)
+			})
+		})
+
+		Convey("with policy "+string(NoAddressLastKnown), func() {
+			conf.NoAddressPolicy = NoAddressLastKnown
+			d := &Discovery{conf: *conf, lastKnown: map[string]string{}, log: log}
+
+			Convey("without a known address", func() {
+				xs := extract(log, conf, []types.Container{c}, nil)
+				d.resolveLastKnown(xs)
+
+				Convey("should not be exported", func() {
+					So(xs[0].IsExported(), ShouldBeFalse)
+				})
+			})
+
+			Convey("when the container had an IP address in the previous refresh", func() {
+				running := c
+				running.State = "running"
+				running.NetworkSettings = &types.SummaryNetworkSettings{
+					Networks: map[string]*network.EndpointSettings{
+						"metrics-net": {IPAddress: "ip1"}}}
+				d.resolveLastKnown(extract(log, conf, []types.Container{running}, nil))
+
+				xs := extract(log, conf, []types.Container{c}, nil)
+				d.resolveLastKnown(xs)
+
+				Convey("should be exported with the last known address", func() {
+					So(xs[0].IsExported(), ShouldBeTrue)
+					So(xs[0].Address, ShouldEqual, "ip1:2000")
+					So(xs[0].Labels[model.AddressLabel], ShouldEqual, "ip1:2000")
+				})
+
+				Convey("should forget the address, when the container is removed", func() {
+					d.resolveLastKnown(nil)
+					So(d.lastKnown, ShouldBeEmpty)
+				})
+			})
+		})
+	})
+}
+
+func TestAddExposedPorts(t *testing.T) {
+	Convey("given Docker API with a restarting and an exited container, without ports, both exposing port 2000", t, func() {
+		var inspected []string
+		var mu sync.Mutex
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Api-Version", "1.45")
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/containers/json"):
+				_ = json.NewEncoder(w).Encode([]types.Container{
+					{ID: "c1", Names: []string{"/restarting"}, State: StateRestarting,
+						Labels: map[string]string{defaultKeys.job: "job1"},
+						NetworkSettings: &types.SummaryNetworkSettings{
+							Networks: map[string]*network.EndpointSettings{"metrics-net": {}}}},
+					{ID: "c2", Names: []string{"/exited"}, State: StateExited,
+						Labels: map[string]string{defaultKeys.job: "job1"},
+						NetworkSettings: &types.SummaryNetworkSettings{
+							Networks: map[string]*network.EndpointSettings{"metrics-net": {}}}}})
+			case strings.HasSuffix(r.URL.Path, "/json") && strings.Contains(r.URL.Path, "/containers/"):
+				id := strings.Split(strings.TrimSuffix(r.URL.Path, "/json"), "/containers/")[1]
+				mu.Lock()
+				inspected = append(inspected, id)
+				mu.Unlock()
+				fmt.Fprintf(w, `{"Id": "%s", "Config": {"ExposedPorts": {"2000/tcp": {}}}}`, id)
+			case strings.HasSuffix(r.URL.Path, "/networks"):
+				_ = json.NewEncoder(w).Encode([]network.Summary{})
+			default:
+				w.WriteHeader(http.StatusOK)
+			}
+		}))
+		defer server.Close()
+
+		d, err := New(&Config{
+			DockerHost:      server.URL,
+			InstancePrefix:  "host1",
+			TargetNetworks:  TargetNetworks{Names: []string{"metrics-net"}},
+			RefreshInterval: 5 * time.Second})
+		So(err, ShouldBeNil)
+		xs, err := d.Refresh(context.Background())
+		So(err, ShouldBeNil)
+		So(xs, ShouldHaveLength, 2)
+		byName := map[string]Meta{}
+		for _, x := range xs {
+			byName[x.Name] = x
+		}
+
+		Convey("should only inspect the restarting container", func() {
+			mu.Lock()
+			defer mu.Unlock()
+			So(inspected, ShouldResemble, []string{"c1"})
+		})
+
+		Convey("the restarting container should be exported with the marker address", func() {
+			x := byName["/restarting"]
+			So(x.IsExported(), ShouldBeTrue)
+			So(x.Address, ShouldEqual, "no-address.invalid:2000")
+		})
+
+		Convey("the exited container should have no TCP ports and not be exported", func() {
+			x := byName["/exited"]
+			So(x.IsExported(), ShouldBeFalse)
+			So(x.HasTCPPorts, ShouldBeFalse)
+			So(x.Reason(), ShouldEqual, "no_tcp_ports")
+		})
+	})
+}
+
+func TestGracePeriod(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given discovery with grace period 1m, and an exported container", t, func() {
+		c := types.Container{
+			ID:     "containerID",
+			Names:  []string{"/containerName"},
+			Labels: map[string]string{"prometheus_job": "job1"},
+			Ports:  []types.Port{{Type: "tcp", PrivatePort: 2000}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "ip1"}}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}},
+			GracePeriod:    time.Minute}
+		d := &Discovery{conf: *conf, grace: map[string]graceTarget{}, log: log}
+
+		t0 := time.Now()
+		xs := d.applyGracePeriod(extract(log, conf, []types.Container{c}, nil), t0)
+		So(xs, ShouldHaveLength, 1)
+		So(xs[0].IsStale, ShouldBeFalse)
+
+		Convey("when the container disappears", func() {
+			xs := d.applyGracePeriod(extract(log, conf, nil, nil), t0.Add(30*time.Second))
+
+			Convey("should still be exported, but stale", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].IsStale, ShouldBeTrue)
+				So(xs[0].IsExported(), ShouldBeTrue)
+				So(xs[0].Address, ShouldEqual, "ip1:2000")
+			})
+
+			Convey("after the grace period", func() {
+				xs := d.applyGracePeriod(extract(log, conf, nil, nil), t0.Add(61*time.Second))
+
+				Convey("should be gone", func() {
+					So(xs, ShouldBeEmpty)
+					So(d.grace, ShouldBeEmpty)
+				})
+			})
+		})
+
+		Convey("when the container loses its IP address", func() {
+			conf.NoAddressPolicy = NoAddressDrop
+			c.NetworkSettings.Networks["metrics-net"] = &network.EndpointSettings{}
+			xs := d.applyGracePeriod(extract(log, conf, []types.Container{c}, nil), t0.Add(30*time.Second))
+
+			Convey("should replace the entry with the stale target", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].IsStale, ShouldBeTrue)
+				So(xs[0].Address, ShouldEqual, "ip1:2000")
+			})
+		})
+
+		Convey("when the container loses its IP address, with the default policy", func() {
+			c.NetworkSettings.Networks["metrics-net"] = &network.EndpointSettings{}
+			xs := d.applyGracePeriod(extract(log, conf, []types.Container{c}, nil), t0.Add(30*time.Second))
+
+			Convey("should replace the marker address with the stale target", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].IsStale, ShouldBeTrue)
+				So(xs[0].Address, ShouldEqual, "ip1:2000")
+			})
+
+			Convey("should not refresh the grace entry", func() {
+				So(d.grace[targetKey(xs[0])].lastSeen, ShouldEqual, t0)
+			})
+
+			Convey("after the grace period", func() {
+				xs := d.applyGracePeriod(extract(log, conf, []types.Container{c}, nil), t0.Add(61*time.Second))
+
+				Convey("should have the marker address", func() {
+					So(xs, ShouldHaveLength, 1)
+					So(xs[0].IsStale, ShouldBeFalse)
+					So(xs[0].Address, ShouldEqual, "no-address.invalid:2000")
+					So(d.grace, ShouldBeEmpty)
+				})
+			})
+		})
+
+		Convey("when the container is recreated with a new ID and IP address", func() {
+			recreated := c
+			recreated.ID = "newContainerID"
+			recreated.NetworkSettings = &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "ip2"}}}
+			xs := d.applyGracePeriod(extract(log, conf, []types.Container{recreated}, nil), t0.Add(30*time.Second))
+
+			Convey("should only export the new target", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].ID, ShouldEqual, "newContainerID")
+				So(xs[0].IsStale, ShouldBeFalse)
+				So(xs[0].Address, ShouldEqual, "ip2:2000")
+			})
+
+			Convey("should forget the old target", func() {
+				So(d.grace, ShouldHaveLength, 1)
+				So(d.grace, ShouldNotContainKey, "containerID/")
+			})
+		})
+
+		Convey("when the container reappears", func() {
+			d.applyGracePeriod(extract(log, conf, nil, nil), t0.Add(30*time.Second))
+			xs := d.applyGracePeriod(extract(log, conf, []types.Container{c}, nil), t0.Add(40*time.Second))
+
+			Convey("should no longer be stale", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].IsStale, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestExtractCompose(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given compose container with prometheus_job label, in target network", t, func() {
+		c := types.Container{
+			ID:    "containerID",
+			Names: []string{"/shop-web-2"},
+			Labels: map[string]string{
+				"prometheus_job":                      "job1",
+				"com.docker.compose.project":          "shop",
+				"com.docker.compose.service":          "web",
+				"com.docker.compose.container-number": "2"},
+			Ports: []types.Port{{Type: "tcp", PrivatePort: 8080}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "ip1"}}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+		xs := extract(log, conf, []types.Container{c}, nil)
+
+		Convey("should have compose labels", func() {
+			So(xs, ShouldHaveLength, 1)
+			So(xs[0].Labels[dockerLabelComposeProject], ShouldEqual, "shop")
+			So(xs[0].Labels[dockerLabelComposeService], ShouldEqual, "web")
+			So(xs[0].Labels[dockerLabelComposeContainerNumber], ShouldEqual, "2")
+		})
+
+		Convey("should have instance from container name", func() {
+			So(xs[0].Labels[model.InstanceLabel], ShouldEqual, "host1/shop-web-2:8080")
+		})
+
+		Convey("with compose instance naming", func() {
+			conf.InstanceNaming = InstanceByCompose
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should have instance from project, service and container number", func() {
+				So(xs[0].Labels[model.InstanceLabel], ShouldEqual, "host1/shop/web/2:8080")
+			})
+
+			Convey("and not from compose", func() {
+				delete(c.Labels, "com.docker.compose.project")
+				xs := extract(log, conf, []types.Container{c}, nil)
+
+				Convey("should fall back to container name", func() {
+					So(xs[0].Labels, ShouldNotContainKey, dockerLabelComposeProject)
+					So(xs[0].Labels[model.InstanceLabel], ShouldEqual, "host1/shop-web-2:8080")
+				})
+			})
+		})
+	})
+}
+
+func TestExtractPrometheusIO(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given container with prometheus.io/* labels, in target network with 2 exposed ports", t, func() {
+		c := types.Container{
+			ID:    "containerID",
+			Names: []string{"/containerName"},
+			Labels: map[string]string{
+				"prometheus.io/scrape": "true",
+				"prometheus.io/port":   "9100",
+				"prometheus.io/path":   "/custom/metrics",
+				"prometheus.io/scheme": "https"},
+			Ports: []types.Port{{Type: "tcp", PrivatePort: 8080}, {Type: "tcp", PrivatePort: 9100}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "ip1"}}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+		Convey("without prometheus.io compatibility", func() {
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should not have job", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].HasJob, ShouldBeFalse)
+			})
+		})
+
+		Convey("with prometheus.io compatibility", func() {
+			conf.PrometheusIO = true
+			conf.PrometheusIOJob = "fallback"
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should be exported with the fallback job and the scrape settings", func() {
+				So(xs, ShouldHaveLength, 1)
+				x := xs[0]
+				So(x.IsExported(), ShouldBeTrue)
+				So(x.Labels[model.JobLabel], ShouldEqual, "fallback")
+				So(x.Address, ShouldEqual, "ip1:9100")
+				So(x.HasExplicitPort, ShouldBeTrue)
+				So(x.Labels[model.MetricsPathLabel], ShouldEqual, "/custom/metrics")
+				So(x.Labels[model.SchemeLabel], ShouldEqual, "https")
+			})
+
+			Convey("should not pass the prometheus.io labels on", func() {
+				So(xs[0].Labels, ShouldNotHaveKeyWithPrefix, "io_")
+				So(xs[0].Labels, ShouldNotHaveKeyWithPrefix, dockerLabelContainerLabelPrefix+"prometheus_io")
+			})
+
+			Convey("and prometheus_* labels", func() {
+				c.Labels["prometheus_job"] = "job1"
+				c.Labels[defaultKeys.scrapePort] = "8080"
+				c.Labels[defaultKeys.scrapePath] = "/metrics"
+				xs := extract(log, conf, []types.Container{c}, nil)
+
+				Convey("should take the prometheus_* labels", func() {
+					x := xs[0]
+					So(x.Labels[model.JobLabel], ShouldEqual, "job1")
+					So(x.Address, ShouldEqual, "ip1:8080")
+					So(x.Labels[model.MetricsPathLabel], ShouldEqual, "/metrics")
+					So(x.Labels[model.SchemeLabel], ShouldEqual, "https")
+				})
+			})
+
+			Convey("and prometheus.io/scrape=false", func() {
+				c.Labels["prometheus.io/scrape"] = "false"
+				xs := extract(log, conf, []types.Container{c}, nil)
+
+				Convey("should not have job", func() {
+					So(xs[0].HasJob, ShouldBeFalse)
+				})
+			})
+		})
+	})
+}
+
+func TestExtractLabelPrefix(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given container with both promteam_* and prometheus_* labels, in target network", t, func() {
+		c := types.Container{
+			ID:    "containerID",
+			Names: []string{"/containerName"},
+			Labels: map[string]string{
+				"prometheus_job":           "job1",
+				"prometheus_scrape_port":   "8080",
+				"promteam_job":             "team1",
+				"promteam_scrape_port":     "9100",
+				"promteam_team":            "a",
+				"promteam_endpoint_x_job":  "teamx",
+				"promteam_endpoint_x_port": "8080"},
+			Ports: []types.Port{{Type: "tcp", PrivatePort: 8080}, {Type: "tcp", PrivatePort: 9100}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "ip1"}}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			LabelPrefix:    "promteam_",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+		xs := extract(log, conf, []types.Container{c}, nil)
+
+		Convey("should only react to the promteam_* labels", func() {
+			So(xs, ShouldHaveLength, 2)
+			x := xs[0]
+			So(x.Labels[model.JobLabel], ShouldEqual, "team1")
+			So(x.Address, ShouldEqual, "ip1:9100")
+			So(x.Labels["team"], ShouldEqual, "a")
+			So(xs[1].Endpoint, ShouldEqual, "x")
+			So(xs[1].Labels[model.JobLabel], ShouldEqual, "teamx")
+			So(xs[1].Address, ShouldEqual, "ip1:8080")
+		})
+
+		Convey("should pass the prometheus_* labels on as container labels", func() {
+			So(xs[0].Labels[dockerLabelContainerLabelPrefix+"prometheus_job"], ShouldEqual, "job1")
+		})
+	})
+
+	Convey("label prefix validation", t, func() {
+		So(ValidateLabelPrefix("promteam_"), ShouldBeNil)
+		So(ValidateLabelPrefix(""), ShouldNotBeNil)
+		So(ValidateLabelPrefix("prom.team"), ShouldNotBeNil)
+	})
+}
+
+func TestExtractLabelFilter(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given container with compose and build labels, in target network", t, func() {
+		c := types.Container{
+			ID:    "containerID",
+			Names: []string{"/containerName"},
+			Labels: map[string]string{
+				"prometheus_job":                  "job1",
+				"com.docker.compose.project":      "shop",
+				"com.docker.compose.config-hash":  "abc",
+				"org.opencontainers.image.source": "src",
+				"org.opencontainers.image.title":  "title",
+				"build_arg_1":                     "x"},
+			Ports: []types.Port{{Type: "tcp", PrivatePort: 8080}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "ip1"}}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+		Convey("with default excludes", func() {
+			filter, err := NewLabelFilter(nil, DefaultLabelExcludes)
+			So(err, ShouldBeNil)
+			conf.LabelFilter = filter
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should drop the compose internals", func() {
+				So(xs[0].Labels, ShouldNotContainKey, dockerLabelContainerLabelPrefix+"com_docker_compose_config_hash")
+				So(xs[0].Labels, ShouldContainKey, dockerLabelContainerLabelPrefix+"com_docker_compose_project")
+				So(xs[0].DroppedLabels, ShouldEqual, 1)
+			})
+
+			Convey("should still be exported with job", func() {
+				So(xs[0].IsExported(), ShouldBeTrue)
+			})
+		})
+
+		Convey("with exact, prefix and regex excludes", func() {
+			filter, err := NewLabelFilter(nil, []string{"build_arg_1", "org.opencontainers.*", "~com\\.docker\\..*hash"})
+			So(err, ShouldBeNil)
+			conf.LabelFilter = filter
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should only keep the compose project", func() {
+				So(xs[0].Labels, ShouldNotHaveKeyWithPrefix, dockerLabelContainerLabelPrefix+"org_")
+				So(xs[0].Labels, ShouldNotContainKey, dockerLabelContainerLabelPrefix+"build_arg_1")
+				So(xs[0].Labels, ShouldContainKey, dockerLabelContainerLabelPrefix+"com_docker_compose_project")
+				So(xs[0].DroppedLabels, ShouldEqual, 4)
+			})
+		})
+
+		Convey("with include and exclude", func() {
+			filter, err := NewLabelFilter([]string{"org.opencontainers.*"}, []string{"org.opencontainers.image.title"})
+			So(err, ShouldBeNil)
+			conf.LabelFilter = filter
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should keep the included, but not the excluded", func() {
+				So(xs[0].Labels, ShouldContainKey, dockerLabelContainerLabelPrefix+"org_opencontainers_image_source")
+				So(xs[0].Labels, ShouldNotContainKey, dockerLabelContainerLabelPrefix+"org_opencontainers_image_title")
+				So(xs[0].DroppedLabels, ShouldEqual, 4)
+			})
+		})
+	})
+
+	Convey("given invalid regex rule", t, func() {
+		_, err := NewLabelFilter(nil, []string{"~("})
+
+		Convey("should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestExtractOptOut(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given compose container without prometheus_job label, in target network", t, func() {
+		c := types.Container{
+			ID:    "containerID",
+			Names: []string{"/shop-web-2"},
+			Image: "registry.example.com:5000/team/web-app:1.2",
+			Labels: map[string]string{
+				"com.docker.compose.project":          "shop",
+				"com.docker.compose.service":          "web",
+				"com.docker.compose.container-number": "2"},
+			Ports: []types.Port{{Type: "tcp", PrivatePort: 8080}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "ip1"}}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+		Convey("should not be exported in opt-in mode", func() {
+			xs := extract(log, conf, []types.Container{c}, nil)
+			So(xs, ShouldHaveLength, 1)
+			So(xs[0].HasJob, ShouldBeFalse)
+			So(xs[0].IsExported(), ShouldBeFalse)
+		})
+
+		Convey("in opt-out mode", func() {
+			conf.OptOut = true
+			conf.JobSource = JobFromComposeService
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should be exported with job from the compose service", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].IsExported(), ShouldBeTrue)
+				So(xs[0].JobDerived, ShouldBeTrue)
+				So(xs[0].Labels[model.JobLabel], ShouldEqual, "web")
+			})
+
+			Convey("with job from the image", func() {
+				conf.JobSource = JobFromImage
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs[0].Labels[model.JobLabel], ShouldEqual, "web-app")
+			})
+
+			Convey("with job from the container name", func() {
+				conf.JobSource = JobFromContainerName
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs[0].Labels[model.JobLabel], ShouldEqual, "shop-web-2")
+			})
+
+			Convey("with explicit job, should not be derived", func() {
+				c.Labels["prometheus_job"] = "job1"
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs[0].JobDerived, ShouldBeFalse)
+				So(xs[0].Labels[model.JobLabel], ShouldEqual, "job1")
+			})
+
+			Convey("with prometheus_scrape=false, should not be exported", func() {
+				c.Labels["prometheus_scrape"] = "false"
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].HasJob, ShouldBeFalse)
+				So(xs[0].IsExported(), ShouldBeFalse)
+				So(xs[0].Labels, ShouldNotContainKey, "scrape")
+			})
+
+			Convey("with named endpoint only, should not add the default endpoint", func() {
+				c.Labels["prometheus_endpoint_jmx_port"] = "9404"
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].Endpoint, ShouldEqual, "jmx")
+				So(xs[0].JobDerived, ShouldBeTrue)
+				So(xs[0].Labels[model.JobLabel], ShouldEqual, "web")
+			})
+
+			Convey("outside the target network, should have no job", func() {
+				c.NetworkSettings = &types.SummaryNetworkSettings{
+					Networks: map[string]*network.EndpointSettings{
+						"other-net": {IPAddress: "ip2"}}}
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].HasJob, ShouldBeFalse)
+				So(xs[0].JobDerived, ShouldBeFalse)
+				So(xs[0].Labels, ShouldNotContainKey, model.JobLabel)
+				So(xs[0].Reason(), ShouldEqual, "no_job")
+			})
+
+			Convey("without TCP ports, should have no job", func() {
+				c.Ports = nil
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].HasJob, ShouldBeFalse)
+				So(xs[0].Reason(), ShouldEqual, "no_job")
+			})
+		})
+
+		Convey("with prometheus_job and prometheus_scrape=false", func() {
+			c.Labels["prometheus_job"] = "job1"
+			c.Labels["prometheus_scrape"] = "false"
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should be excluded", func() {
+				So(xs[0].IsExported(), ShouldBeFalse)
+				So(xs[0].ExcludedReason, ShouldEqual, "opted out by prometheus_scrape=false")
+			})
+		})
+	})
+}
+
+func TestImageName(t *testing.T) {
+	Convey("image name", t, func() {
+		So(imageName("app"), ShouldEqual, "app")
+		So(imageName("app:1.2"), ShouldEqual, "app")
+		So(imageName("team/app:1.2"), ShouldEqual, "app")
+		So(imageName("registry:5000/team/app:1.2@sha256:abc"), ShouldEqual, "app")
+		So(imageName("sha256:abc"), ShouldEqual, "")
+	})
+}
+
+func TestExtractScrapeParams(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given container with scrape params, in target network", t, func() {
+		c := types.Container{
+			ID:    "containerID",
+			Names: []string{"/snmp"},
+			Labels: map[string]string{
+				"prometheus_job":                     "snmp",
+				"prometheus_scrape_path":             "/snmp",
+				"prometheus_scrape_param_module":     "if_mib,ip_mib",
+				"prometheus_scrape_param_target":     "192.168.1.2",
+				"prometheus_scrape_param_auth-token": "x",
+				"prometheus_endpoint_jmx_port":       "9404"},
+			Ports: []types.Port{{Type: "tcp", PrivatePort: 9116}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "ip1"}}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+		xs := extract(log, conf, []types.Container{c}, nil)
+		So(xs, ShouldHaveLength, 2)
+
+		Convey("should have the param labels", func() {
+			So(xs[0].Labels[model.ParamLabelPrefix+"module"], ShouldEqual, "if_mib,ip_mib")
+			So(xs[0].Labels[model.ParamLabelPrefix+"target"], ShouldEqual, "192.168.1.2")
+		})
+
+		Convey("should ignore the param with invalid name", func() {
+			So(xs[0].Labels, ShouldNotContainKey, model.ParamLabelPrefix+"auth_token")
+			So(xs[0].Labels, ShouldNotContainKey, "scrape_param_auth_token")
+		})
+
+		Convey("should have the effective scrape URL", func() {
+			So(xs[0].ScrapeURL(), ShouldEqual, "http://ip1:9116/snmp?module=if_mib%2Cip_mib&target=192.168.1.2")
+		})
+
+		Convey("the named endpoint should inherit the params", func() {
+			So(xs[1].Endpoint, ShouldEqual, "jmx")
+			So(xs[1].Labels[model.ParamLabelPrefix+"module"], ShouldEqual, "if_mib,ip_mib")
+		})
+	})
+}
+
+func TestExtractProblems(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given container with prometheus_job label, in target network, with exposed port 8080", t, func() {
+		c := types.Container{
+			ID:     "containerID",
+			Names:  []string{"/containerName"},
+			Labels: map[string]string{"prometheus_job": "job1"},
+			Ports:  []types.Port{{Type: "tcp", PrivatePort: 8080}, {Type: "tcp", PrivatePort: 8081}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "ip1"}}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+		reasons := func(m Meta) []ProblemReason {
+			result := make([]ProblemReason, 0)
+			for _, p := range m.Problems {
+				result = append(result, p.Reason)
+			}
+			return result
+		}
+
+		Convey("with valid labels, should have no problems", func() {
+			c.Labels["prometheus_scrape_port"] = "8080"
+			c.Labels["prometheus_scrape_interval"] = "30s"
+			c.Labels["prometheus_scrape_timeout"] = "10s"
+			c.Labels["prometheus_scrape_scheme"] = "https"
+			xs := extract(log, conf, []types.Container{c}, nil)
+			So(xs[0].Problems, ShouldBeEmpty)
+			So(xs[0].IsExported(), ShouldBeTrue)
+		})
+
+		Convey("with non-numeric scrape port, should not be exported", func() {
+			c.Labels["prometheus_scrape_port"] = "http"
+			xs := extract(log, conf, []types.Container{c}, nil)
+			So(reasons(xs[0]), ShouldContain, ProblemInvalidPort)
+			So(xs[0].IsExported(), ShouldBeFalse)
+		})
+
+		Convey("with scrape port not exposed, should warn, but be exported", func() {
+			c.Labels["prometheus_scrape_port"] = "9090"
+			xs := extract(log, conf, []types.Container{c}, nil)
+			So(reasons(xs[0]), ShouldResemble, []ProblemReason{ProblemPortNotExposed})
+			So(xs[0].Problems[0].Message, ShouldContainSubstring, "8080, 8081")
+			So(xs[0].IsExported(), ShouldBeTrue)
+		})
+
+		Convey("with unparsable interval and timeout, should not be exported", func() {
+			c.Labels["prometheus_scrape_interval"] = "30 seconds"
+			c.Labels["prometheus_scrape_timeout"] = "ten"
+			xs := extract(log, conf, []types.Container{c}, nil)
+			So(reasons(xs[0]), ShouldContain, ProblemInvalidInterval)
+			So(reasons(xs[0]), ShouldContain, ProblemInvalidTimeout)
+			So(xs[0].IsExported(), ShouldBeFalse)
+		})
+
+		Convey("with timeout greater than interval, should not be exported", func() {
+			c.Labels["prometheus_scrape_interval"] = "10s"
+			c.Labels["prometheus_scrape_timeout"] = "1m"
+			xs := extract(log, conf, []types.Container{c}, nil)
+			So(reasons(xs[0]), ShouldResemble, []ProblemReason{ProblemTimeoutExceedsInterval})
+			So(xs[0].IsExported(), ShouldBeFalse)
+		})
+
+		Convey("with unknown scheme, should not be exported", func() {
+			c.Labels["prometheus_scrape_scheme"] = "ftp"
+			xs := extract(log, conf, []types.Container{c}, nil)
+			So(reasons(xs[0]), ShouldContain, ProblemUnknownScheme)
+			So(xs[0].IsExported(), ShouldBeFalse)
+		})
+
+		Convey("with invalid scrape param name, should warn, but be exported", func() {
+			c.Labels["prometheus_scrape_param_auth-token"] = "x"
+			xs := extract(log, conf, []types.Container{c}, nil)
+			So(reasons(xs[0]), ShouldResemble, []ProblemReason{ProblemInvalidParamName})
+			So(xs[0].IsExported(), ShouldBeTrue)
+		})
+
+		Convey("with out-of-range scrape port, should only report the invalid port", func() {
+			c.Labels["prometheus_scrape_port"] = "70000"
+			xs := extract(log, conf, []types.Container{c}, nil)
+			So(reasons(xs[0]), ShouldResemble, []ProblemReason{ProblemInvalidPort})
+			So(xs[0].IsExported(), ShouldBeFalse)
+		})
+
+		Convey("without exposed ports", func() {
+			c.Ports = nil
+
+			Convey("with scrape port, should have no problems", func() {
+				c.Labels["prometheus_scrape_port"] = "9090"
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs[0].Problems, ShouldBeEmpty)
+				So(xs[0].Address, ShouldEqual, "ip1:9090")
+				So(xs[0].IsExported(), ShouldBeTrue)
+			})
+
+			Convey("with non-numeric scrape port, should only report the invalid port", func() {
+				c.Labels["prometheus_scrape_port"] = "abc"
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(reasons(xs[0]), ShouldResemble, []ProblemReason{ProblemInvalidPort})
+				So(xs[0].HasTCPPorts, ShouldBeFalse)
+				So(xs[0].IsExported(), ShouldBeFalse)
+			})
+
+			Convey("with out-of-range scrape port, should only report the invalid port", func() {
+				c.Labels["prometheus_scrape_port"] = "70000"
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(reasons(xs[0]), ShouldResemble, []ProblemReason{ProblemInvalidPort})
+				So(xs[0].HasTCPPorts, ShouldBeFalse)
+				So(xs[0].IsExported(), ShouldBeFalse)
+			})
+		})
+
+		Convey("with invalid named endpoint port, only that endpoint should have the problem", func() {
+			c.Labels["prometheus_endpoint_jmx_port"] = "jmx"
+			xs := extract(log, conf, []types.Container{c}, nil)
+			So(xs, ShouldHaveLength, 2)
+			So(xs[0].Endpoint, ShouldEqual, "jmx")
+			So(reasons(xs[0]), ShouldContain, ProblemInvalidPort)
+			So(xs[1].Endpoint, ShouldEqual, "")
+			So(xs[1].Problems, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestExtractProbes(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given container with prometheus_job and probe labels, in target network", t, func() {
+		c := types.Container{
+			ID:    "containerID",
+			Names: []string{"/web"},
+			Labels: map[string]string{
+				"prometheus_job":               "job1",
+				"prometheus_scrape_path":       "/internal/metrics",
+				"prometheus_probe_module":      "http_2xx",
+				"prometheus_probe_path":        "/health",
+				"prometheus_endpoint_jmx_port": "9404"},
+			Ports: []types.Port{{Type: "tcp", PrivatePort: 8080}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "ip1"}}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+		Convey("without probe address, should not add probes", func() {
+			xs := addProbes(conf, extract(log, conf, []types.Container{c}, nil))
+			So(xs, ShouldHaveLength, 2)
+			So(xs[0].Labels, ShouldNotContainKey, "probe_module")
+		})
+
+		Convey("with probe address", func() {
+			conf.ProbeAddress = "blackbox:9115"
+			conf.ProbeJob = "blackbox"
+			xs := addProbes(conf, extract(log, conf, []types.Container{c}, nil))
+
+			Convey("should add 1 probe after the scrape targets", func() {
+				So(xs, ShouldHaveLength, 3)
+				So(xs[0].IsProbe, ShouldBeFalse)
+				So(xs[1].IsProbe, ShouldBeTrue)
+				So(xs[1].Endpoint, ShouldEqual, "")
+				So(xs[2].Endpoint, ShouldEqual, "jmx")
+				So(xs[2].IsProbe, ShouldBeFalse)
+			})
+
+			Convey("the probe should target the container via the blackbox exporter", func() {
+				p := xs[1]
+				So(p.IsExported(), ShouldBeTrue)
+				So(p.Address, ShouldEqual, "blackbox:9115")
+				So(p.Labels[model.AddressLabel], ShouldEqual, "blackbox:9115")
+				So(p.Labels[model.JobLabel], ShouldEqual, "blackbox")
+				So(p.Labels[model.MetricsPathLabel], ShouldEqual, "/probe")
+				So(p.Labels[model.ParamLabelPrefix+"module"], ShouldEqual, "http_2xx")
+				So(p.Labels[model.ParamLabelPrefix+"target"], ShouldEqual, "http://ip1:8080/health")
+				So(p.Labels[model.InstanceLabel], ShouldEqual, "http://ip1:8080/health")
+				So(p.ScrapeURL(), ShouldEqual, "http://blackbox:9115/probe?module=http_2xx&target=http%3A%2F%2Fip1%3A8080%2Fhealth")
+			})
+
+			Convey("the scrape target should be unchanged", func() {
+				So(xs[0].Address, ShouldEqual, "ip1:8080")
+				So(xs[0].Labels[model.JobLabel], ShouldEqual, "job1")
+				So(xs[0].Labels[model.MetricsPathLabel], ShouldEqual, "/internal/metrics")
+			})
+
+			Convey("with probe port and job, without path", func() {
+				c.Labels["prometheus_probe_module"] = "tcp_connect"
+				c.Labels["prometheus_probe_port"] = "5432"
+				c.Labels["prometheus_probe_job"] = "db-probe"
+				delete(c.Labels, "prometheus_probe_path")
+				xs := addProbes(conf, extract(log, conf, []types.Container{c}, nil))
+
+				Convey("should target host and port", func() {
+					So(xs[1].IsProbe, ShouldBeTrue)
+					So(xs[1].Labels[model.ParamLabelPrefix+"target"], ShouldEqual, "ip1:5432")
+					So(xs[1].Labels[model.JobLabel], ShouldEqual, "db-probe")
+				})
+			})
+
+			Convey("without prometheus_job, should only have the probe exported", func() {
+				delete(c.Labels, "prometheus_job")
+				delete(c.Labels, "prometheus_endpoint_jmx_port")
+				xs := addProbes(conf, extract(log, conf, []types.Container{c}, nil))
+				So(xs, ShouldHaveLength, 2)
+				So(xs[0].IsExported(), ShouldBeFalse)
+				So(xs[1].IsProbe, ShouldBeTrue)
+				So(xs[1].IsExported(), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestMetaReason(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given container with prometheus_job label and 2 exposed ports", t, func() {
+		c := types.Container{
+			ID:     "containerID",
+			Names:  []string{"/containerName"},
+			State:  "running",
+			Labels: map[string]string{"prometheus_job": "job1"},
+			Ports:  []types.Port{{Type: "tcp", PrivatePort: 8080}, {Type: "tcp", PrivatePort: 8081}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"other-net": {IPAddress: "ip1"}}}}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+		Convey("not in target network, should have the reason", func() {
+			xs := extract(log, conf, []types.Container{c}, nil)
+			So(xs[0].State, ShouldEqual, "running")
+			So(xs[0].Reason(), ShouldEqual, "not_in_target_network")
+		})
+
+		Convey("in target network", func() {
+			c.NetworkSettings.Networks["metrics-net"] = &network.EndpointSettings{IPAddress: "ip2"}
+
+			Convey("without scrape port, should be exported with warning", func() {
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs[0].IsExported(), ShouldBeTrue)
+				So(xs[0].Reason(), ShouldEqual, "multiple_ports")
+			})
+
+			Convey("with scrape port, should be exported without reason", func() {
+				c.Labels["prometheus_scrape_port"] = "8081"
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs[0].Reason(), ShouldEqual, "")
+			})
+
+			Convey("with invalid scrape port, should have the problem as reason", func() {
+				c.Labels["prometheus_scrape_port"] = "http"
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs[0].Reason(), ShouldEqual, string(ProblemInvalidPort))
+			})
+
+			Convey("excluded by state, should have the reason", func() {
+				c.State = "exited"
+				conf.ExportPolicy = ExportPolicy{ExcludeStates: []string{"exited"}}
+				xs := extract(log, conf, []types.Container{c}, nil)
+				So(xs[0].State, ShouldEqual, "exited")
+				So(xs[0].Reason(), ShouldEqual, "excluded")
+			})
+		})
+	})
+}
@@ -0,0 +1,105 @@
+package docker
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+const (
+	minEventBackoff = 1 * time.Second
+	maxEventBackoff = 1 * time.Minute
+)
+
+// events that may change the discovered targets
+func eventFilters(mode Mode) filters.Args {
+	if mode == ModeSwarm {
+		// tasks have no events, but service and node events are cluster wide
+		return filters.NewArgs(
+			filters.Arg("type", string(events.ServiceEventType)),
+			filters.Arg("type", string(events.NodeEventType)))
+	}
+
+	return filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("type", string(events.NetworkEventType)),
+		filters.Arg("event", string(events.ActionStart)),
+		filters.Arg("event", string(events.ActionDie)),
+		filters.Arg("event", string(events.ActionDestroy)),
+		filters.Arg("event", string(events.ActionRename)),
+		filters.Arg("event", string(events.ActionPause)),
+		filters.Arg("event", string(events.ActionUnPause)),
+		filters.Arg("event", string(events.ActionHealthStatus)),
+		filters.Arg("event", string(events.ActionConnect)),
+		filters.Arg("event", string(events.ActionDisconnect)))
+}
+
+// Watch subscribes to the Docker events stream and sends on trigger when
+// containers or their networks change. Bursts of events within the debounce
+// period are collapsed into a single trigger. The stream is reopened with
+// backoff when it drops. Blocks until ctx is cancelled.
+func (d *Discovery) Watch(ctx context.Context, trigger chan<- struct{}) {
+	log := d.log.With("context", "events")
+	backoff := minEventBackoff
+	for {
+		received, err := d.watch(ctx, log, trigger)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			backoff = minEventBackoff
+		}
+
+		metric_event_stream_errors.Inc()
+		log.Warn("event stream dropped, will reconnect", "error", err, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxEventBackoff)
+
+		// events may have been missed while disconnected
+		notify(trigger)
+	}
+}
+
+// watch the events stream until it fails. Returns whether any events were received
+func (d *Discovery) watch(ctx context.Context, log *slog.Logger, trigger chan<- struct{}) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	msgs, errs := d.client.Events(ctx, events.ListOptions{Filters: eventFilters(d.conf.Mode)})
+	log.Debug("subscribed to events")
+
+	var received bool
+	var debounce <-chan time.Time
+	for {
+		select {
+		case err := <-errs:
+			return received, err
+		case m := <-msgs:
+			received = true
+			metric_events.WithLabelValues(string(m.Type), string(m.Action)).Inc()
+			log.Debug("event received", "type", m.Type, "action", m.Action, "actor", m.Actor.ID)
+			if debounce == nil {
+				debounce = time.After(d.conf.EventDebounce)
+			}
+		case <-debounce:
+			debounce = nil
+			notify(trigger)
+		}
+	}
+}
+
+// non-blocking send. A pending trigger is enough
+func notify(trigger chan<- struct{}) {
+	select {
+	case trigger <- struct{}{}:
+	default:
+	}
+}
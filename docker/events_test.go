@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fake Docker API, that streams the given events and then keeps the stream open
+func eventsServer(xs []events.Message) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/events") {
+			w.Header().Set("Api-Version", "1.45")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		for _, x := range xs {
+			_ = enc.Encode(x)
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+}
+
+func TestWatch(t *testing.T) {
+	Convey("given Docker API streaming a burst of 3 container events", t, func() {
+		server := eventsServer([]events.Message{
+			{Type: events.ContainerEventType, Action: events.ActionDie, Actor: events.Actor{ID: "c1"}},
+			{Type: events.ContainerEventType, Action: events.ActionStart, Actor: events.Actor{ID: "c1"}},
+			{Type: events.NetworkEventType, Action: events.ActionConnect, Actor: events.Actor{ID: "n1"}}})
+		defer server.Close()
+
+		d, err := New(&Config{
+			DockerHost:      server.URL,
+			RefreshInterval: time.Minute,
+			EventDebounce:   50 * time.Millisecond})
+		So(err, ShouldBeNil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		trigger := make(chan struct{}, 10)
+		go d.Watch(ctx, trigger)
+
+		Convey("should trigger a single refresh", func() {
+			select {
+			case <-trigger:
+			case <-time.After(2 * time.Second):
+				t.Fatal("no refresh triggered")
+			}
+
+			select {
+			case <-trigger:
+				t.Fatal("burst triggered more than 1 refresh")
+			case <-time.After(200 * time.Millisecond):
+			}
+		})
+	})
+}
@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultLabelPrefix of the container labels, e.g. prometheus_job
+const DefaultLabelPrefix = "prometheus_"
+
+var labelPrefixPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidateLabelPrefix checks that the prefix is usable in label names
+func ValidateLabelPrefix(prefix string) error {
+	if !labelPrefixPattern.MatchString(prefix) {
+		return fmt.Errorf("invalid label prefix '%s', must match %s", prefix, labelPrefixPattern.String())
+	}
+	return nil
+}
+
+// keys of the container labels to extract, derived from the label prefix.
+// Several instances with different prefixes may discover the same host, each
+// only reacting to its own labels
+type labelKeys struct {
+	prefix         string // e.g. prometheus_, other labels with the prefix are added to the output labels
+	job            string // e.g. prometheus_job
+	scrape         string // e.g. prometheus_scrape, false to opt out
+	scrapePrefix   string // e.g. prometheus_scrape_
+	scrapePort     string
+	scrapeInterval string
+	scrapeTimeout  string
+	scrapePath     string
+	scrapeScheme   string
+	scrapeExternal string
+	scrapeNetwork  string
+	scrapeParam    string // e.g. prometheus_scrape_param_, prefix of the URL parameters
+	endpointPrefix string // e.g. prometheus_endpoint_
+	probePrefix    string // e.g. prometheus_probe_
+	probeModule    string
+	probePath      string
+	probePort      string
+	probeJob       string
+}
+
+func newLabelKeys(prefix string) labelKeys {
+	scrapePrefix := prefix + "scrape_"
+	probePrefix := prefix + "probe_"
+	return labelKeys{
+		prefix:         prefix,
+		job:            prefix + "job",
+		scrape:         prefix + "scrape",
+		scrapePrefix:   scrapePrefix,
+		scrapePort:     scrapePrefix + "port",
+		scrapeInterval: scrapePrefix + "interval",
+		scrapeTimeout:  scrapePrefix + "timeout",
+		scrapePath:     scrapePrefix + "path",
+		scrapeScheme:   scrapePrefix + "scheme",
+		scrapeExternal: scrapePrefix + "external",
+		scrapeNetwork:  scrapePrefix + "network",
+		scrapeParam:    scrapePrefix + "param_",
+		endpointPrefix: prefix + "endpoint_",
+		probePrefix:    probePrefix,
+		probeModule:    probePrefix + "module",
+		probePath:      probePrefix + "path",
+		probePort:      probePrefix + "port",
+		probeJob:       probePrefix + "job"}
+}
+
+// label keys of the configured prefix, or the default
+func (c *Config) labelKeys() labelKeys {
+	if c.LabelPrefix == "" {
+		return newLabelKeys(DefaultLabelPrefix)
+	}
+	return newLabelKeys(c.LabelPrefix)
+}
+
+// DefaultLabelExcludes are the noisy docker compose internals. The project,
+// service and container number are kept
+var DefaultLabelExcludes = []string{
+	"com.docker.compose.config-hash",
+	"com.docker.compose.depends_on",
+	"com.docker.compose.image",
+	"com.docker.compose.oneoff",
+	"com.docker.compose.project.config_files",
+	"com.docker.compose.project.environment_file",
+	"com.docker.compose.project.working_dir",
+	"com.docker.compose.replace",
+	"com.docker.compose.version"}
+
+// LabelFilter selects the Docker labels of containers and networks, that are
+// added as meta labels. A label is kept when it matches any include rule (or
+// there are none), and no exclude rule
+type LabelFilter struct {
+	Include []labelRule
+	Exclude []labelRule
+}
+
+// rule matching a Docker label name. Either exact, a prefix 'name*' or an anchored regular expression '~pattern'
+type labelRule struct {
+	name     string // exact name or prefix
+	isPrefix bool
+	pattern  *regexp.Regexp
+}
+
+// NewLabelFilter from include and exclude rules, each being an exact label
+// name, a prefix ending with '*' or a regular expression starting with '~'
+func NewLabelFilter(include, exclude []string) (LabelFilter, error) {
+	var f LabelFilter
+	var err error
+	if f.Include, err = parseLabelRules(include); err != nil {
+		return f, fmt.Errorf("invalid include rule: %w", err)
+	}
+	if f.Exclude, err = parseLabelRules(exclude); err != nil {
+		return f, fmt.Errorf("invalid exclude rule: %w", err)
+	}
+	return f, nil
+}
+
+func parseLabelRules(xs []string) ([]labelRule, error) {
+	result := make([]labelRule, 0, len(xs))
+	for _, x := range xs {
+		switch {
+		case strings.HasPrefix(x, "~"):
+			re, err := regexp.Compile("^(?:" + x[1:] + ")$")
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, labelRule{pattern: re})
+		case strings.HasSuffix(x, "*"):
+			result = append(result, labelRule{name: strings.TrimSuffix(x, "*"), isPrefix: true})
+		default:
+			result = append(result, labelRule{name: x})
+		}
+	}
+	return result, nil
+}
+
+func (r labelRule) match(name string) bool {
+	switch {
+	case r.pattern != nil:
+		return r.pattern.MatchString(name)
+	case r.isPrefix:
+		return strings.HasPrefix(name, r.name)
+	default:
+		return name == r.name
+	}
+}
+
+// whether the Docker label should be added as meta label
+func (f LabelFilter) keep(name string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, name) {
+		return false
+	}
+	return !matchAny(f.Exclude, name)
+}
+
+func matchAny(rules []labelRule, name string) bool {
+	for _, r := range rules {
+		if r.match(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package docker
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	namespace = "prometheus_docker_sd"
+)
+
+var (
+	metric_events = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "docker_events_total",
+		Help:      "Number of Docker events received, that may trigger a refresh"},
+		[]string{"type", "action"})
+
+	metric_event_stream_errors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "docker_event_stream_errors_total",
+		Help:      "Number of times the Docker events stream failed or dropped, and had to be reopened"})
+
+	metric_ssh_connections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssh_connections_total",
+		Help:      "Number of connections opened to ssh:// Docker hosts. Connections are reopened when dropped"},
+		[]string{"docker_host"})
+
+	metric_ssh_connection_errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssh_connection_errors_total",
+		Help:      "Number of connections to ssh:// Docker hosts, that failed"},
+		[]string{"docker_host"})
+)
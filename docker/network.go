@@ -16,6 +16,9 @@ package docker
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
@@ -33,7 +36,7 @@ const (
 	labelNetworkLabelPrefix = labelNetworkPrefix + "label_"
 )
 
-func getNetworksLabels(ctx context.Context, client *client.Client, labelPrefix string) (map[string]map[string]string, error) {
+func getNetworksLabels(ctx context.Context, client *client.Client, labelPrefix string, filter LabelFilter) (map[string]map[string]string, error) {
 	networks, err := client.NetworkList(ctx, network.ListOptions{})
 	if err != nil {
 		return nil, err
@@ -48,6 +51,9 @@ func getNetworksLabels(ctx context.Context, client *client.Client, labelPrefix s
 			labelPrefix + labelNetworkIngress:  fmt.Sprintf("%t", network.Ingress),
 		}
 		for k, v := range network.Labels {
+			if !filter.keep(k) {
+				continue
+			}
 			ln := strutil.SanitizeLabelName(k)
 			labels[network.ID][labelPrefix+labelNetworkLabelPrefix+ln] = v
 		}
@@ -55,3 +61,97 @@ func getNetworksLabels(ctx context.Context, client *client.Client, labelPrefix s
 
 	return labels, nil
 }
+
+// TargetNetworks selects the network a container is scraped in, when it is a
+// member of several. Names take precedence in the order given, then networks
+// matching Pattern in alphabetical order.
+type TargetNetworks struct {
+	Names   []string
+	Pattern *regexp.Regexp // anchored, optional
+}
+
+// NewTargetNetworks from a list of names and an optional regular expression, which is anchored
+func NewTargetNetworks(names []string, pattern string) (TargetNetworks, error) {
+	t := TargetNetworks{Names: names}
+	if pattern != "" {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return t, fmt.Errorf("invalid target network pattern: %w", err)
+		}
+		t.Pattern = re
+	}
+	return t, nil
+}
+
+func (t TargetNetworks) IsEmpty() bool {
+	return len(t.Names) == 0 && t.Pattern == nil
+}
+
+// String representation, e.g. 'net1,net2,~team-.*'. Used for logs and metric labels
+func (t TargetNetworks) String() string {
+	xs := append([]string{}, t.Names...)
+	if t.Pattern != nil {
+		p := t.Pattern.String()
+		xs = append(xs, "~"+p[len("^(?:"):len(p)-len(")$")])
+	}
+	return strings.Join(xs, ",")
+}
+
+// Select the target network among the networks of a container
+func (t TargetNetworks) Select(networks map[string]*network.EndpointSettings) (string, *network.EndpointSettings, bool) {
+	for _, name := range t.Names {
+		if n, found := networks[name]; found {
+			return name, n, true
+		}
+	}
+
+	if t.Pattern == nil {
+		return "", nil, false
+	}
+
+	candidates := make([]string, 0)
+	for name := range networks {
+		if t.Pattern.MatchString(name) {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", nil, false
+	}
+	sort.Strings(candidates)
+	return candidates[0], networks[candidates[0]], true
+}
+
+// AddressFamily policy for selecting the IP of a container in the target network
+type AddressFamily string
+
+const (
+	IPv4       AddressFamily = "ipv4"
+	IPv6       AddressFamily = "ipv6"
+	PreferIPv6 AddressFamily = "prefer-ipv6" // IPv6 when available, otherwise IPv4
+)
+
+func ParseAddressFamily(s string) (AddressFamily, error) {
+	switch f := AddressFamily(strings.ToLower(s)); f {
+	case IPv4, IPv6, PreferIPv6:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid address family '%s', must be one of %s, %s or %s", s, IPv4, IPv6, PreferIPv6)
+	}
+}
+
+// select IP of network endpoint. Empty if the endpoint has no address in the family.
+// Defaults to IPv4
+func (f AddressFamily) selectIP(n *network.EndpointSettings) string {
+	switch f {
+	case IPv6:
+		return n.GlobalIPv6Address
+	case PreferIPv6:
+		if n.GlobalIPv6Address != "" {
+			return n.GlobalIPv6Address
+		}
+		return n.IPAddress
+	default:
+		return n.IPAddress
+	}
+}
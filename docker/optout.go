@@ -0,0 +1,60 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// JobSource is what the job of containers without job label is derived from, in opt-out mode
+type JobSource string
+
+const (
+	JobFromComposeService JobSource = "compose-service" // compose service, otherwise the container name
+	JobFromImage          JobSource = "image"           // image name, without registry, tag and digest
+	JobFromContainerName  JobSource = "container-name"  // container name, without the leading '/'
+)
+
+func ParseJobSource(s string) (JobSource, error) {
+	switch x := JobSource(s); x {
+	case JobFromComposeService, JobFromImage, JobFromContainerName:
+		return x, nil
+	default:
+		return "", fmt.Errorf("invalid job source '%s', must be one of %s, %s or %s", s, JobFromComposeService, JobFromImage, JobFromContainerName)
+	}
+}
+
+// job of the container in opt-out mode
+func derivedJob(source JobSource, c types.Container) string {
+	name := strings.TrimPrefix(c.Names[0], "/")
+	switch source {
+	case JobFromComposeService:
+		if service := c.Labels[composeLabelService]; service != "" {
+			return service
+		}
+	case JobFromImage:
+		if image := imageName(c.Image); image != "" {
+			return image
+		}
+	}
+	return name
+}
+
+// last path element of the image reference, without tag and digest, e.g.
+// 'app' for 'registry:5000/team/app:1.2@sha256:...'. Empty for image IDs
+func imageName(ref string) string {
+	if strings.HasPrefix(ref, "sha256:") {
+		return ""
+	}
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		ref = ref[i+1:]
+	}
+	if i := strings.Index(ref, ":"); i >= 0 {
+		ref = ref[:i]
+	}
+	return ref
+}
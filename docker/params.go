@@ -0,0 +1,55 @@
+package docker
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// URL parameter names, that are valid in __param_<name> labels
+var paramNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parse <prefix>scrape_param_<name> label, returning the __param_<name> label. False if the name is invalid
+func parseParamLabel(keys labelKeys, k string) (string, bool) {
+	name := strings.TrimPrefix(k, keys.scrapeParam)
+	if !paramNamePattern.MatchString(name) {
+		return "", false
+	}
+	return model.ParamLabelPrefix + name, true
+}
+
+// ScrapeURL is the effective URL Prometheus scrapes the target on, from the
+// scheme, address, metrics path and __param_* labels. The scheme and path
+// default to http and /metrics, unless set by labels
+func (m Meta) ScrapeURL() string {
+	if m.Address == "" {
+		return ""
+	}
+	u := url.URL{
+		Scheme: m.Labels[model.SchemeLabel],
+		Host:   m.Address,
+		Path:   m.Labels[model.MetricsPathLabel]}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	if u.Path == "" {
+		u.Path = "/metrics"
+	}
+
+	params := url.Values{}
+	keys := make([]string, 0)
+	for k := range m.Labels {
+		if strings.HasPrefix(k, model.ParamLabelPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		params.Set(k[len(model.ParamLabelPrefix):], m.Labels[k])
+	}
+	u.RawQuery = params.Encode()
+	return u.String()
+}
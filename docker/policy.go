@@ -0,0 +1,100 @@
+package docker
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// state of a container
+const (
+	StateCreated    = "created"
+	StateRunning    = "running"
+	StatePaused     = "paused"
+	StateRestarting = "restarting"
+	StateRemoving   = "removing"
+	StateExited     = "exited"
+	StateDead       = "dead"
+)
+
+// health status of a container
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+	HealthNone      = "none" // no healthcheck
+)
+
+// ExportPolicy drops targets by container state and healthcheck status.
+// Excluded containers are still listed, but not exported.
+type ExportPolicy struct {
+	// container states to exclude, one of created, running, paused, restarting, removing, exited or dead
+	ExcludeStates []string
+	// health statuses to exclude, one of starting, healthy, unhealthy or none
+	ExcludeHealth []string
+}
+
+func (p ExportPolicy) Validate() error {
+	for _, s := range p.ExcludeStates {
+		switch s {
+		case StateCreated, StateRunning, StatePaused, StateRestarting, StateRemoving, StateExited, StateDead:
+		default:
+			return fmt.Errorf("invalid container state '%s', must be one of %s, %s, %s, %s, %s, %s or %s",
+				s, StateCreated, StateRunning, StatePaused, StateRestarting, StateRemoving, StateExited, StateDead)
+		}
+	}
+	for _, h := range p.ExcludeHealth {
+		switch h {
+		case HealthStarting, HealthHealthy, HealthUnhealthy, HealthNone:
+		default:
+			return fmt.Errorf("invalid health status '%s', must be one of %s, %s, %s or %s",
+				h, HealthStarting, HealthHealthy, HealthUnhealthy, HealthNone)
+		}
+	}
+	return nil
+}
+
+// reason for excluding the container, empty if not excluded
+func (p ExportPolicy) excluded(state, health string) string {
+	if slices.Contains(p.ExcludeStates, state) {
+		return "container state " + state
+	}
+	if slices.Contains(p.ExcludeHealth, health) {
+		return "health " + health
+	}
+	return ""
+}
+
+// health status parsed from the container status, e.g. 'Up 5 minutes (healthy)'.
+// The container list does not include the health otherwise
+func containerHealth(status string) string {
+	switch {
+	case strings.Contains(status, "(health: starting)"):
+		return HealthStarting
+	case strings.Contains(status, "(unhealthy)"):
+		return HealthUnhealthy
+	case strings.Contains(status, "(healthy)"):
+		return HealthHealthy
+	default:
+		return HealthNone
+	}
+}
+
+// NoAddressPolicy for containers in the target network without an IP address,
+// e.g. when a container continues to restart
+type NoAddressPolicy string
+
+const (
+	NoAddressDrop      NoAddressPolicy = "drop"       // do not export the target
+	NoAddressLastKnown NoAddressPolicy = "last-known" // last known address of the container, otherwise drop
+	NoAddressMarker    NoAddressPolicy = "marker"     // export with a marker address, that never resolves
+)
+
+func ParseNoAddressPolicy(s string) (NoAddressPolicy, error) {
+	switch p := NoAddressPolicy(strings.ToLower(s)); p {
+	case NoAddressDrop, NoAddressLastKnown, NoAddressMarker:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid no-address policy '%s', must be one of %s, %s or %s", s, NoAddressDrop, NoAddressLastKnown, NoAddressMarker)
+	}
+}
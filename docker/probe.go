@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"net"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	blackboxMetricsPath = "/probe"
+	paramModule         = model.ParamLabelPrefix + "module"
+	paramTarget         = model.ParamLabelPrefix + "target"
+)
+
+// blackbox exporter probe of a container, from the <prefix>probe_* labels
+type probe struct {
+	module string
+	path   string // when set, the probe target is an URL, otherwise <host>:<port>
+	port   string // overrides the port of the scrape target
+	job    string // overrides the probe job of the Config
+}
+
+// add a probe target for each target with probe labels, when a blackbox
+// exporter is configured. Must be added after the addresses are resolved
+func addProbes(conf *Config, xs []Meta) []Meta {
+	if conf.ProbeAddress == "" {
+		return xs
+	}
+
+	result := xs
+	for _, x := range xs {
+		if x.probe != nil {
+			result = append(result, probeMeta(conf, x))
+		}
+	}
+	sortMetas(result)
+	return result
+}
+
+// the probe target of the container. The scrape settings are replaced by
+// those of the blackbox exporter, with the target as the instance
+func probeMeta(conf *Config, x Meta) Meta {
+	p := x.probe
+	meta := x
+	meta.probe = nil
+	meta.IsProbe = true
+	meta.HasJob = true
+	meta.JobDerived = false
+	meta.Problems = append([]Problem{}, x.Problems...)
+	meta.Labels = make(map[string]string, len(x.Labels))
+	for k, v := range x.Labels {
+		if k == model.SchemeLabel || k == model.MetricsPathLabel || strings.HasPrefix(k, model.ParamLabelPrefix) {
+			continue
+		}
+		meta.Labels[k] = v
+	}
+
+	meta.Labels[model.JobLabel] = conf.ProbeJob
+	if p.job != "" {
+		meta.Labels[model.JobLabel] = p.job
+	}
+	meta.Labels[model.MetricsPathLabel] = blackboxMetricsPath
+	meta.Labels[paramModule] = p.module
+
+	if p.port != "" {
+		meta.Problems = append(meta.Problems, validateEndpoint(endpoint{port: p.port})...)
+	}
+	if x.Address == "" {
+		// not exported, for the same reason as the scrape target
+		return meta
+	}
+	host, port, err := net.SplitHostPort(x.Address)
+	if err != nil {
+		return meta
+	}
+	if p.port != "" {
+		port = p.port
+	}
+
+	target := net.JoinHostPort(host, port)
+	if p.path != "" {
+		scheme := x.Labels[model.SchemeLabel]
+		if scheme == "" {
+			scheme = "http"
+		}
+		target = scheme + "://" + target + p.path
+	}
+	meta.Labels[paramTarget] = target
+	meta.Labels[model.InstanceLabel] = target
+	meta.Address = conf.ProbeAddress
+	meta.Labels[model.AddressLabel] = conf.ProbeAddress
+	return meta
+}
@@ -0,0 +1,116 @@
+package docker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/common/model"
+)
+
+// ProblemReason is the type of a problem with the labels of a container
+type ProblemReason string
+
+const (
+	ProblemInvalidPort            ProblemReason = "invalid_port"             // scrape port is not a port number
+	ProblemPortNotExposed         ProblemReason = "port_not_exposed"         // scrape port is not among the exposed ports
+	ProblemInvalidInterval        ProblemReason = "invalid_interval"         // scrape interval is not a duration
+	ProblemInvalidTimeout         ProblemReason = "invalid_timeout"          // scrape timeout is not a duration
+	ProblemTimeoutExceedsInterval ProblemReason = "timeout_exceeds_interval" // scrape timeout is greater than the interval
+	ProblemUnknownScheme          ProblemReason = "unknown_scheme"           // scheme is neither http nor https
+	ProblemInvalidParamName       ProblemReason = "invalid_param_name"       // scrape param name is not a valid label name
+	ProblemMissingEndpointPort    ProblemReason = "missing_endpoint_port"    // named endpoint without port
+)
+
+// ProblemReasons are all reasons, e.g. to reset metrics with
+var ProblemReasons = []ProblemReason{
+	ProblemInvalidPort,
+	ProblemPortNotExposed,
+	ProblemInvalidInterval,
+	ProblemInvalidTimeout,
+	ProblemTimeoutExceedsInterval,
+	ProblemUnknownScheme,
+	ProblemInvalidParamName,
+	ProblemMissingEndpointPort}
+
+// IsWarning is true for problems, that do not prevent the target from being
+// exported. The others would be rejected by Prometheus
+func (r ProblemReason) IsWarning() bool {
+	return r == ProblemPortNotExposed || r == ProblemInvalidParamName
+}
+
+// Problem with the labels of a container, with a human-readable message
+type Problem struct {
+	Reason  ProblemReason
+	Message string
+}
+
+func newProblem(reason ProblemReason, format string, args ...interface{}) Problem {
+	return Problem{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// whether any problem prevents the target from being exported
+func hasErrors(xs []Problem) bool {
+	for _, x := range xs {
+		if !x.Reason.IsWarning() {
+			return true
+		}
+	}
+	return false
+}
+
+// problems with the scrape settings of the endpoint
+func validateEndpoint(e endpoint) []Problem {
+	var result []Problem
+	if e.name != "" && e.port == "" {
+		// the lowest port would most likely be the one of the default endpoint
+		result = append(result, newProblem(ProblemMissingEndpointPort, "endpoint '%s' has no port", e.name))
+	}
+	if e.port != "" {
+		if _, ok := parsePort(e.port); !ok {
+			result = append(result, newProblem(ProblemInvalidPort, "scrape port '%s' is not a port number", e.port))
+		}
+	}
+
+	var interval, timeout model.Duration
+	var err error
+	if v, exists := e.scrape[model.ScrapeIntervalLabel]; exists {
+		if interval, err = model.ParseDuration(v); err != nil {
+			result = append(result, newProblem(ProblemInvalidInterval, "scrape interval '%s' is not a duration, e.g. 30s", v))
+		}
+	}
+	if v, exists := e.scrape[model.ScrapeTimeoutLabel]; exists {
+		if timeout, err = model.ParseDuration(v); err != nil {
+			result = append(result, newProblem(ProblemInvalidTimeout, "scrape timeout '%s' is not a duration, e.g. 10s", v))
+		}
+	}
+	if interval > 0 && timeout > interval {
+		result = append(result, newProblem(ProblemTimeoutExceedsInterval, "scrape timeout %s is greater than the interval %s", timeout, interval))
+	}
+
+	if v, exists := e.scrape[model.SchemeLabel]; exists && v != "http" && v != "https" {
+		result = append(result, newProblem(ProblemUnknownScheme, "scheme '%s' is neither http nor https", v))
+	}
+	return result
+}
+
+// port number 1-65535
+func parsePort(s string) (uint16, bool) {
+	p, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || p == 0 {
+		return 0, false
+	}
+	return uint16(p), true
+}
+
+// TCP ports, e.g. '80, 443'
+func formatPorts(xs []types.Port) string {
+	result := make([]string, 0, len(xs))
+	for _, x := range xs {
+		if x.Type == "tcp" {
+			result = append(result, strconv.FormatUint(uint64(x.PrivatePort), 10))
+		}
+	}
+	return strings.Join(result, ", ")
+}
@@ -0,0 +1,34 @@
+package docker
+
+import (
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// Kubernetes style annotation labels, as carried by many third-party images
+const (
+	prometheusIOPrefix = "prometheus.io/"
+	prometheusIOScrape = prometheusIOPrefix + "scrape"
+	prometheusIOPort   = prometheusIOPrefix + "port"
+	prometheusIOPath   = prometheusIOPrefix + "path"
+	prometheusIOScheme = prometheusIOPrefix + "scheme"
+)
+
+// apply the prometheus.io/* labels to the default endpoint. Must be applied
+// before the prometheus_* labels, which take precedence. The job is the
+// fallback job, when prometheus.io/scrape is true and prometheus_job is not set
+func applyPrometheusIO(e *endpoint, labels map[string]string, job string) {
+	if e.job == "" && strings.ToLower(labels[prometheusIOScrape]) == "true" {
+		e.job = job
+	}
+	if v, exists := labels[prometheusIOPort]; exists {
+		e.port = v
+	}
+	if v, exists := labels[prometheusIOPath]; exists {
+		e.scrape[model.MetricsPathLabel] = v
+	}
+	if v, exists := labels[prometheusIOScheme]; exists {
+		e.scrape[model.SchemeLabel] = v
+	}
+}
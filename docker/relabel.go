@@ -0,0 +1,66 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v3"
+)
+
+// file with the relabel rules, in the format of the Prometheus scrape config
+type relabelFile struct {
+	RelabelConfigs []*relabel.Config `yaml:"relabel_configs"`
+}
+
+// LoadRelabelConfigs reads the 'relabel_configs' of the YAML file. Invalid
+// rules fail the loading
+func LoadRelabelConfigs(path string) ([]*relabel.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f relabelFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal: %w", err)
+	}
+	for i, c := range f.RelabelConfigs {
+		if c == nil {
+			return nil, fmt.Errorf("relabel rule %d is empty", i+1)
+		}
+	}
+	return f.RelabelConfigs, nil
+}
+
+// apply the relabel rules to the exported targets. A target dropped by a rule
+// keeps its labels, but records the rule. Otherwise the labels are replaced,
+// and the address is taken from __address__
+func applyRelabelConfigs(xs []Meta, cfgs []*relabel.Config) {
+	if len(cfgs) == 0 {
+		return
+	}
+
+	for i := range xs {
+		if !xs[i].IsExported() {
+			continue
+		}
+
+		lbls := labels.FromMap(xs[i].Labels)
+		for j, c := range cfgs {
+			var keep bool
+			if lbls, keep = relabel.Process(lbls, c); !keep {
+				xs[i].DroppedByRelabelRule = j + 1
+				break
+			}
+		}
+		if xs[i].DroppedByRelabelRule > 0 {
+			continue
+		}
+
+		xs[i].Labels = lbls.Map()
+		xs[i].Address = xs[i].Labels[model.AddressLabel]
+	}
+}
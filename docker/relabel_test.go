@@ -0,0 +1,134 @@
+package docker
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/prometheus/common/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoadRelabelConfigs(t *testing.T) {
+	Convey("given relabel config file", t, func() {
+		path := filepath.Join(t.TempDir(), "relabel.yml")
+
+		Convey("with valid rules, should load them in order", func() {
+			So(os.WriteFile(path, []byte(`
+relabel_configs:
+  - source_labels: [__meta_docker_container_name]
+    regex: /test-.*
+    action: drop
+  - regex: __meta_docker_container_label_team
+    replacement: team
+    action: labelmap
+`), 0600), ShouldBeNil)
+
+			cfgs, err := LoadRelabelConfigs(path)
+			So(err, ShouldBeNil)
+			So(cfgs, ShouldHaveLength, 2)
+			So(string(cfgs[0].Action), ShouldEqual, "drop")
+			So(string(cfgs[1].Action), ShouldEqual, "labelmap")
+		})
+
+		Convey("with unknown action, should fail", func() {
+			So(os.WriteFile(path, []byte(`
+relabel_configs:
+  - action: explode
+`), 0600), ShouldBeNil)
+
+			_, err := LoadRelabelConfigs(path)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("with replace rule without target label, should fail", func() {
+			So(os.WriteFile(path, []byte(`
+relabel_configs:
+  - source_labels: [job]
+    action: replace
+`), 0600), ShouldBeNil)
+
+			_, err := LoadRelabelConfigs(path)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("with invalid regex, should fail", func() {
+			So(os.WriteFile(path, []byte(`
+relabel_configs:
+  - source_labels: [job]
+    regex: "("
+    action: keep
+`), 0600), ShouldBeNil)
+
+			_, err := LoadRelabelConfigs(path)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestApplyRelabelConfigs(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given exported containers 'app' and 'test-app'", t, func() {
+		container := func(id, name string) types.Container {
+			return types.Container{
+				ID:    id,
+				Names: []string{name},
+				Labels: map[string]string{
+					"prometheus_job": "job1",
+					"team":           "a"},
+				Ports: []types.Port{{Type: "tcp", PrivatePort: 8080}},
+				NetworkSettings: &types.SummaryNetworkSettings{
+					Networks: map[string]*network.EndpointSettings{
+						"metrics-net": {IPAddress: "ip-" + id}}}}
+		}
+		conf := &Config{
+			InstancePrefix: "host1",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+		xs := extract(log, conf, []types.Container{container("id1", "/app"), container("id2", "/test-app")}, nil)
+		So(xs, ShouldHaveLength, 2)
+
+		path := filepath.Join(t.TempDir(), "relabel.yml")
+		So(os.WriteFile(path, []byte(`
+relabel_configs:
+  - regex: __meta_docker_container_label_(team)
+    action: labelmap
+  - source_labels: [__meta_docker_container_name]
+    regex: /test-.*
+    action: drop
+  - source_labels: [__address__]
+    regex: (.*):8080
+    replacement: $1:9090
+    target_label: __address__
+`), 0600), ShouldBeNil)
+		cfgs, err := LoadRelabelConfigs(path)
+		So(err, ShouldBeNil)
+
+		applyRelabelConfigs(xs, cfgs)
+
+		Convey("the kept target should have the mapped label", func() {
+			So(xs[0].Name, ShouldEqual, "/app")
+			So(xs[0].IsExported(), ShouldBeTrue)
+			So(xs[0].Labels, ShouldContainKey, "team")
+		})
+
+		Convey("the kept target should have the rewritten address", func() {
+			So(xs[0].Address, ShouldEqual, "ip-id1:9090")
+			So(xs[0].Labels[model.AddressLabel], ShouldEqual, "ip-id1:9090")
+		})
+
+		Convey("the dropped target should record rule 2", func() {
+			So(xs[1].Name, ShouldEqual, "/test-app")
+			So(xs[1].IsExported(), ShouldBeFalse)
+			So(xs[1].DroppedByRelabelRule, ShouldEqual, 2)
+		})
+
+		Convey("the dropped target should keep the labels before relabeling", func() {
+			So(xs[1].Labels, ShouldNotContainKey, "team")
+			So(xs[1].Address, ShouldEqual, "ip-id2:8080")
+		})
+	})
+}
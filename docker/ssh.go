@@ -0,0 +1,184 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// placeholder host of the Docker client, the connection is made by the ssh dialer
+	sshClientHost = "http://docker.example.com"
+	sshCommand    = "ssh"
+)
+
+// dialer tunnelling the Docker API over SSH, as the docker CLI does. The ssh
+// command runs 'docker system dial-stdio' on the remote host, which proxies
+// stdin/stdout to the Docker socket. Authentication is left to ssh, i.e. the
+// agent, keys and known_hosts of the user
+type sshDialer struct {
+	dockerHost string
+	command    string
+	args       []string
+	log        *slog.Logger
+	// whether the last connection failed, to log the reconnect
+	failed atomic.Bool
+}
+
+func newSSHDialer(log *slog.Logger, hostURL *url.URL) (*sshDialer, error) {
+	args, err := sshArgs(hostURL)
+	if err != nil {
+		return nil, err
+	}
+	return &sshDialer{
+		dockerHost: hostURL.String(),
+		command:    sshCommand,
+		args:       args,
+		log:        log.With("context", "ssh")}, nil
+}
+
+// arguments for the ssh command from an URL like ssh://user@host:port/path,
+// where the optional path is the Docker socket on the remote host
+func sshArgs(hostURL *url.URL) ([]string, error) {
+	if hostURL.Scheme != "ssh" {
+		return nil, fmt.Errorf("expected ssh scheme, got '%s'", hostURL.Scheme)
+	}
+	if _, hasPassword := hostURL.User.Password(); hasPassword {
+		return nil, fmt.Errorf("plain-text password is not supported in ssh host, use keys or the ssh agent")
+	}
+	if hostURL.Hostname() == "" {
+		return nil, fmt.Errorf("no host specified in ssh host")
+	}
+	if hostURL.RawQuery != "" || hostURL.Fragment != "" {
+		return nil, fmt.Errorf("extra query or fragment not supported in ssh host")
+	}
+
+	// never prompt, as there is no terminal
+	args := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=30"}
+	if user := hostURL.User.Username(); user != "" {
+		args = append(args, "-l", user)
+	}
+	if port := hostURL.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, "--", hostURL.Hostname(), "docker")
+	if hostURL.Path != "" && hostURL.Path != "/" {
+		args = append(args, "--host", "unix://"+hostURL.Path)
+	}
+	return append(args, "system", "dial-stdio"), nil
+}
+
+// DialContext starts the ssh command. The network and address are ignored
+func (d *sshDialer) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	metric_ssh_connections.WithLabelValues(d.dockerHost).Inc()
+	if d.failed.Load() {
+		d.log.Info("reconnecting over ssh")
+	} else {
+		d.log.Debug("connecting over ssh")
+	}
+
+	// not bound to ctx, since the connection outlives the request
+	cmd := exec.Command(d.command, d.args...)
+	c := &sshConn{cmd: cmd, dialer: d}
+	cmd.Stderr = &c.stderr
+
+	var err error
+	if c.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, d.fail(err)
+	}
+	if c.stdout, err = cmd.StdoutPipe(); err != nil {
+		return nil, d.fail(err)
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, d.fail(fmt.Errorf("failed to start %s: %w", d.command, err))
+	}
+	return c, nil
+}
+
+func (d *sshDialer) fail(err error) error {
+	metric_ssh_connection_errors.WithLabelValues(d.dockerHost).Inc()
+	d.failed.Store(true)
+	d.log.Warn("ssh connection failed", "error", err)
+	return err
+}
+
+// net.Conn over stdin/stdout of the ssh command
+type sshConn struct {
+	cmd    *exec.Cmd
+	dialer *sshDialer
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr bytes.Buffer // only read after the command exited
+
+	closed   atomic.Bool
+	waitOnce sync.Once
+	waitErr  error
+}
+
+// wait for the command to exit. An exit not caused by Close is a failed connection
+func (c *sshConn) wait() error {
+	c.waitOnce.Do(func() {
+		err := c.cmd.Wait()
+		if c.closed.Load() {
+			return
+		}
+		if err != nil {
+			c.waitErr = c.dialer.fail(fmt.Errorf("%s exited: %w, %s", c.dialer.command, err, strings.TrimSpace(c.stderr.String())))
+		} else {
+			c.dialer.failed.Store(false)
+		}
+	})
+	return c.waitErr
+}
+
+func (c *sshConn) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if err == io.EOF {
+		if waitErr := c.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	if err == nil {
+		c.dialer.failed.Store(false)
+	}
+	return n, err
+}
+
+func (c *sshConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+func (c *sshConn) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	_ = c.stdin.Close()
+	if c.cmd.Process != nil {
+		_ = c.cmd.Process.Kill()
+	}
+	_ = c.wait()
+	return nil
+}
+
+func (c *sshConn) LocalAddr() net.Addr  { return sshAddr{} }
+func (c *sshConn) RemoteAddr() net.Addr { return sshAddr{} }
+
+// deadlines are not supported by pipes of a command, the requests are bound
+// by the context instead
+func (c *sshConn) SetDeadline(time.Time) error      { return nil }
+func (c *sshConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *sshConn) SetWriteDeadline(time.Time) error { return nil }
+
+type sshAddr struct{}
+
+func (sshAddr) Network() string { return "ssh" }
+func (sshAddr) String() string  { return "ssh" }
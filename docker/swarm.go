@@ -0,0 +1,168 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+const (
+	dockerLabelSwarmPrefix       = dockerLabel + "swarm_"
+	dockerLabelSwarmServiceID    = dockerLabelSwarmPrefix + "service_id"
+	dockerLabelSwarmServiceName  = dockerLabelSwarmPrefix + "service_name"
+	dockerLabelSwarmTaskID       = dockerLabelSwarmPrefix + "task_id"
+	dockerLabelSwarmTaskSlot     = dockerLabelSwarmPrefix + "task_slot"
+	dockerLabelSwarmNodeID       = dockerLabelSwarmPrefix + "node_id"
+	dockerLabelSwarmNodeHostname = dockerLabelSwarmPrefix + "node_hostname"
+)
+
+// Mode of discovery
+type Mode string
+
+const (
+	ModeContainers Mode = "containers" // containers of a single host
+	ModeSwarm      Mode = "swarm"      // tasks of swarm services, 1 target per task (container)
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeContainers, ModeSwarm:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid mode '%s', must be one of %s or %s", s, ModeContainers, ModeSwarm)
+	}
+}
+
+func (d *Discovery) refreshSwarm(ctx context.Context) ([]Meta, error) {
+	services, err := d.client.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error while listing swarm services: %w", err)
+	}
+
+	tasks, err := d.client.TaskList(ctx, types.TaskListOptions{
+		Filters: filters.NewArgs(filters.Arg("desired-state", string(swarm.TaskStateRunning)))})
+	if err != nil {
+		return nil, fmt.Errorf("error while listing swarm tasks: %w", err)
+	}
+
+	nodes, err := d.client.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error while listing swarm nodes: %w", err)
+	}
+
+	networkLabels, err := getNetworksLabels(ctx, d.client, dockerLabel, d.conf.LabelFilter)
+	if err != nil {
+		return nil, fmt.Errorf("error while computing network labels: %w", err)
+	}
+
+	return extractSwarm(d.log, &d.conf, services, tasks, nodes, networkLabels), nil
+}
+
+// extract 1 Meta per endpoint of running tasks. The labels of the service and
+// the container spec (taking precedence) are applied with the same rules as
+// for containers
+func extractSwarm(parentLog *slog.Logger, conf *Config, services []swarm.Service, tasks []swarm.Task, nodes []swarm.Node, networkLabels map[string]map[string]string) []Meta {
+	servicesByID := make(map[string]swarm.Service, len(services))
+	for _, s := range services {
+		servicesByID[s.ID] = s
+	}
+	nodesByID := make(map[string]swarm.Node, len(nodes))
+	for _, n := range nodes {
+		nodesByID[n.ID] = n
+	}
+
+	result := make([]Meta, 0)
+	for _, t := range tasks {
+		if t.Status.State != swarm.TaskStateRunning {
+			continue
+		}
+		s, found := servicesByID[t.ServiceID]
+		if !found {
+			continue
+		}
+
+		c := taskContainer(s, t)
+		log := parentLog.With(
+			"service", s.Spec.Name,
+			"task", t.ID,
+			"name", c.Names[0])
+
+		for _, meta := range extractContainer(log, conf, c, networkLabels) {
+			meta.Labels[dockerLabelSwarmServiceID] = s.ID
+			meta.Labels[dockerLabelSwarmServiceName] = s.Spec.Name
+			meta.Labels[dockerLabelSwarmTaskID] = t.ID
+			meta.Labels[dockerLabelSwarmTaskSlot] = strconv.Itoa(t.Slot)
+			meta.Labels[dockerLabelSwarmNodeID] = t.NodeID
+			if n, found := nodesByID[t.NodeID]; found {
+				meta.Labels[dockerLabelSwarmNodeHostname] = n.Description.Hostname
+			}
+			result = append(result, meta)
+		}
+	}
+
+	sortMetas(result)
+	return result
+}
+
+// represent the task as a container, to apply the same extract rules. The
+// name is '/<service>.<slot>', or '/<service>.<node ID>' for global services,
+// to be stable across task updates
+func taskContainer(s swarm.Service, t swarm.Task) types.Container {
+	c := types.Container{
+		ID:     t.ID,
+		State:  string(t.Status.State),
+		Labels: make(map[string]string),
+		NetworkSettings: &types.SummaryNetworkSettings{
+			Networks: make(map[string]*network.EndpointSettings)}}
+
+	if t.Status.ContainerStatus != nil && t.Status.ContainerStatus.ContainerID != "" {
+		c.ID = t.Status.ContainerStatus.ContainerID
+	}
+
+	if t.Slot > 0 {
+		c.Names = []string{"/" + s.Spec.Name + "." + strconv.Itoa(t.Slot)}
+	} else {
+		c.Names = []string{"/" + s.Spec.Name + "." + t.NodeID}
+	}
+
+	for k, v := range s.Spec.Labels {
+		c.Labels[k] = v
+	}
+	if t.Spec.ContainerSpec != nil {
+		for k, v := range t.Spec.ContainerSpec.Labels {
+			c.Labels[k] = v
+		}
+	}
+
+	for _, p := range s.Endpoint.Ports {
+		c.Ports = append(c.Ports, types.Port{
+			Type:        string(p.Protocol),
+			PrivatePort: uint16(p.TargetPort),
+			PublicPort:  uint16(p.PublishedPort)})
+	}
+
+	for _, a := range t.NetworksAttachments {
+		n := &network.EndpointSettings{NetworkID: a.Network.ID}
+		for _, address := range a.Addresses {
+			ip, _, err := net.ParseCIDR(address)
+			if err != nil {
+				continue
+			}
+			if ip.To4() != nil {
+				n.IPAddress = ip.String()
+			} else {
+				n.GlobalIPv6Address = ip.String()
+			}
+		}
+		c.NetworkSettings.Networks[a.Network.Spec.Name] = n
+	}
+
+	return c
+}
@@ -0,0 +1,235 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/prometheus/common/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExtractSwarm(t *testing.T) {
+	log := slog.Default()
+	conf := &Config{
+		Mode:           ModeSwarm,
+		InstancePrefix: "swarm1",
+		TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+	Convey("given service 'app' with prometheus_job label and 2 replicas in the metrics-net overlay network", t, func() {
+		services := []swarm.Service{{
+			ID: "s1",
+			Spec: swarm.ServiceSpec{
+				Annotations: swarm.Annotations{
+					Name: "app",
+					Labels: map[string]string{
+						"prometheus_job":       "job1",
+						defaultKeys.scrapePath: "/service/metrics"}}},
+			Endpoint: swarm.Endpoint{
+				Ports: []swarm.PortConfig{{Protocol: swarm.PortConfigProtocolTCP, TargetPort: 8080, PublishedPort: 30080}}}}}
+
+		task := func(id string, slot int, nodeID, address string) swarm.Task {
+			return swarm.Task{
+				ID:        id,
+				ServiceID: "s1",
+				Slot:      slot,
+				NodeID:    nodeID,
+				Status: swarm.TaskStatus{
+					State:           swarm.TaskStateRunning,
+					ContainerStatus: &swarm.ContainerStatus{ContainerID: "container-" + id}},
+				Spec: swarm.TaskSpec{
+					ContainerSpec: &swarm.ContainerSpec{
+						Labels: map[string]string{defaultKeys.scrapePath: "/container/metrics"}}},
+				NetworksAttachments: []swarm.NetworkAttachment{
+					{
+						Network:   swarm.Network{ID: "ingressID", Spec: swarm.NetworkSpec{Annotations: swarm.Annotations{Name: "ingress"}}},
+						Addresses: []string{"10.0.0.5/24"}},
+					{
+						Network:   swarm.Network{ID: "metricsID", Spec: swarm.NetworkSpec{Annotations: swarm.Annotations{Name: "metrics-net"}}},
+						Addresses: []string{address}}}}
+		}
+		tasks := []swarm.Task{
+			task("t1", 1, "n1", "10.0.1.11/24"),
+			task("t2", 2, "n2", "10.0.1.12/24"),
+			{ID: "t3", ServiceID: "s1", Slot: 3, Status: swarm.TaskStatus{State: swarm.TaskStatePending}}}
+		nodes := []swarm.Node{
+			{ID: "n1", Description: swarm.NodeDescription{Hostname: "node1"}},
+			{ID: "n2", Description: swarm.NodeDescription{Hostname: "node2"}}}
+		networkLabels := map[string]map[string]string{
+			"metricsID": {dockerLabelNetworkPrefix + "id": "metricsID"}}
+
+		xs := extractSwarm(log, conf, services, tasks, nodes, networkLabels)
+
+		Convey("should have 1 entry per running task", func() {
+			So(xs, ShouldHaveLength, 2)
+
+			x := xs[0]
+			Convey("with address in the overlay network", func() {
+				So(x.Address, ShouldEqual, "10.0.1.11:8080")
+				So(x.IsExported(), ShouldBeTrue)
+				So(x.Labels[dockerLabelNetworkName], ShouldEqual, "metrics-net")
+				So(x.Labels[dockerLabelNetworkPrefix+"id"], ShouldEqual, "metricsID")
+			})
+
+			Convey("with instance from service name and slot", func() {
+				So(x.Name, ShouldEqual, "/app.1")
+				So(x.Labels[model.InstanceLabel], ShouldEqual, "swarm1/app.1:8080")
+			})
+
+			Convey("with job from the service labels", func() {
+				So(x.Labels[model.JobLabel], ShouldEqual, "job1")
+			})
+
+			Convey("with container labels taking precedence over service labels", func() {
+				So(x.Labels[model.MetricsPathLabel], ShouldEqual, "/container/metrics")
+			})
+
+			Convey("with swarm labels", func() {
+				So(x.ID, ShouldEqual, "container-t1")
+				So(x.Labels[dockerLabelSwarmServiceName], ShouldEqual, "app")
+				So(x.Labels[dockerLabelSwarmTaskID], ShouldEqual, "t1")
+				So(x.Labels[dockerLabelSwarmTaskSlot], ShouldEqual, "1")
+				So(x.Labels[dockerLabelSwarmNodeHostname], ShouldEqual, "node1")
+			})
+
+			Convey("second task", func() {
+				So(xs[1].Address, ShouldEqual, "10.0.1.12:8080")
+				So(xs[1].Labels[dockerLabelSwarmNodeHostname], ShouldEqual, "node2")
+			})
+		})
+
+		Convey("without prometheus_job label", func() {
+			delete(services[0].Spec.Labels, defaultKeys.job)
+			xs := extractSwarm(log, conf, services, tasks, nodes, networkLabels)
+
+			Convey("should not be exported", func() {
+				So(xs, ShouldHaveLength, 2)
+				So(xs[0].HasJob, ShouldBeFalse)
+				So(xs[0].IsExported(), ShouldBeFalse)
+			})
+		})
+
+		Convey("global service task (no slot)", func() {
+			tasks := []swarm.Task{task("t1", 0, "n1", "10.0.1.11/24")}
+			xs := extractSwarm(log, conf, services, tasks, nodes, networkLabels)
+
+			Convey("should be named by node ID", func() {
+				So(xs[0].Name, ShouldEqual, "/app.n1")
+			})
+		})
+	})
+}
+
+// fake Docker API of a swarm manager, with service 'app' and a running and a
+// shutdown task in metrics-net. Requests to the path with the suffix fail.
+// The filters of the task list are sent to taskFilters
+func swarmServer(fail string, taskFilters chan<- filters.Args) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Api-Version", "1.45")
+		if fail != "" && strings.HasSuffix(r.URL.Path, fail) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]string{"message": "boom"})
+			return
+		}
+
+		attachment := swarm.NetworkAttachment{
+			Network:   swarm.Network{ID: "metricsID", Spec: swarm.NetworkSpec{Annotations: swarm.Annotations{Name: "metrics-net"}}},
+			Addresses: []string{"10.0.1.11/24"}}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/services"):
+			_ = json.NewEncoder(w).Encode([]swarm.Service{{
+				ID: "s1",
+				Spec: swarm.ServiceSpec{
+					Annotations: swarm.Annotations{Name: "app", Labels: map[string]string{defaultKeys.job: "job1"}}},
+				Endpoint: swarm.Endpoint{
+					Ports: []swarm.PortConfig{{Protocol: swarm.PortConfigProtocolTCP, TargetPort: 8080}}}}})
+		case strings.HasSuffix(r.URL.Path, "/tasks"):
+			args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+			if err == nil && taskFilters != nil {
+				taskFilters <- args
+			}
+			_ = json.NewEncoder(w).Encode([]swarm.Task{
+				{ID: "t1", ServiceID: "s1", Slot: 1, NodeID: "n1",
+					Status:              swarm.TaskStatus{State: swarm.TaskStateRunning},
+					NetworksAttachments: []swarm.NetworkAttachment{attachment}},
+				{ID: "t0", ServiceID: "s1", Slot: 1, NodeID: "n1",
+					Status:              swarm.TaskStatus{State: swarm.TaskStateShutdown},
+					NetworksAttachments: []swarm.NetworkAttachment{attachment}}})
+		case strings.HasSuffix(r.URL.Path, "/nodes"):
+			_ = json.NewEncoder(w).Encode([]swarm.Node{{ID: "n1", Description: swarm.NodeDescription{Hostname: "node1"}}})
+		case strings.HasSuffix(r.URL.Path, "/networks"):
+			_ = json.NewEncoder(w).Encode([]network.Summary{{ID: "metricsID", Name: "metrics-net", Scope: "swarm"}})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+}
+
+func TestRefreshSwarm(t *testing.T) {
+	refresh := func(server *httptest.Server) ([]Meta, error) {
+		d, err := New(&Config{
+			Mode:            ModeSwarm,
+			DockerHost:      server.URL,
+			InstancePrefix:  "swarm1",
+			TargetNetworks:  TargetNetworks{Names: []string{"metrics-net"}},
+			RefreshInterval: 5 * time.Second})
+		if err != nil {
+			return nil, err
+		}
+		return d.Refresh(context.Background())
+	}
+
+	Convey("given swarm manager with service 'app' and a running task", t, func() {
+		taskFilters := make(chan filters.Args, 1)
+		server := swarmServer("", taskFilters)
+		defer server.Close()
+
+		xs, err := refresh(server)
+		So(err, ShouldBeNil)
+
+		Convey("should only list tasks with desired state running", func() {
+			var args filters.Args
+			select {
+			case args = <-taskFilters:
+			default:
+			}
+			So(args.Get("desired-state"), ShouldResemble, []string{string(swarm.TaskStateRunning)})
+		})
+
+		Convey("should have 1 target for the running task", func() {
+			So(xs, ShouldHaveLength, 1)
+			So(xs[0].IsExported(), ShouldBeTrue)
+			So(xs[0].Address, ShouldEqual, "10.0.1.11:8080")
+			So(xs[0].Labels[model.InstanceLabel], ShouldEqual, "swarm1/app.1:8080")
+			So(xs[0].Labels[dockerLabelSwarmNodeHostname], ShouldEqual, "node1")
+			So(xs[0].Labels[dockerLabel+labelNetworkScope], ShouldEqual, "swarm")
+			So(xs[0].Labels[dockerLabelHost], ShouldEqual, server.URL)
+		})
+	})
+
+	for _, x := range []struct{ path, message string }{
+		{"/services", "error while listing swarm services"},
+		{"/tasks", "error while listing swarm tasks"},
+		{"/nodes", "error while listing swarm nodes"},
+		{"/networks", "error while computing network labels"}} {
+		Convey("given swarm manager failing to list "+x.path, t, func() {
+			server := swarmServer(x.path, nil)
+			defer server.Close()
+
+			Convey("refresh should fail", func() {
+				_, err := refresh(server)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, x.message)
+			})
+		})
+	}
+}
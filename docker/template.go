@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is available to the instance and external address templates
+type TemplateData struct {
+	Name           string            // container name, without the leading '/'
+	Endpoint       string            // named endpoint, empty for the default endpoint
+	ComposeProject string            // empty when not from docker compose
+	ComposeService string            // empty when not from docker compose
+	ComposeNumber  string            // container number of the compose service
+	Hostname       string            // external host of the Docker host
+	InstancePrefix string            // instance prefix of the Docker host
+	Port           string            // scrape port
+	IP             string            // IP in the target network, empty for host network or without IP address
+	Labels         map[string]string // Docker labels of the container
+}
+
+// example to validate templates with
+var exampleTemplateData = TemplateData{
+	Name:           "app-1",
+	ComposeProject: "shop",
+	ComposeService: "app",
+	ComposeNumber:  "1",
+	Hostname:       "host1",
+	InstancePrefix: "host1",
+	Port:           "8080",
+	IP:             "10.0.0.2",
+	Labels:         map[string]string{}}
+
+// ParseTargetTemplate parses a text/template for the instance or the external
+// address of targets, see TemplateData. It is validated by executing it with
+// an example, so unknown fields fail at startup rather than on refresh.
+// Missing labels are empty
+func ParseTargetTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(io.Discard, exampleTemplateData); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func render(t *template.Template, data TemplateData) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	result := strings.TrimSpace(b.String())
+	if result == "" {
+		return "", fmt.Errorf("template %s rendered empty", t.Name())
+	}
+	return result, nil
+}
+
+func newTemplateData(conf *Config, meta Meta, containerLabels map[string]string, port, ip string) TemplateData {
+	return TemplateData{
+		Name:           strings.TrimPrefix(meta.Name, "/"),
+		Endpoint:       meta.Endpoint,
+		ComposeProject: meta.Labels[dockerLabelComposeProject],
+		ComposeService: meta.Labels[dockerLabelComposeService],
+		ComposeNumber:  meta.Labels[dockerLabelComposeContainerNumber],
+		Hostname:       conf.ExternalHost,
+		InstancePrefix: conf.InstancePrefix,
+		Port:           port,
+		IP:             ip,
+		Labels:         containerLabels}
+}
+
+// external address of targets scraped via the host, from the template or the external host
+func externalAddress(conf *Config, data TemplateData) (string, error) {
+	if conf.ExternalAddressTemplate == nil {
+		return net.JoinHostPort(conf.ExternalHost, data.Port), nil
+	}
+	address, err := render(conf.ExternalAddressTemplate, data)
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", fmt.Errorf("template %s rendered invalid address '%s': %w", conf.ExternalAddressTemplate.Name(), address, err)
+	}
+	return address, nil
+}
@@ -0,0 +1,116 @@
+package docker
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/prometheus/common/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseTargetTemplate(t *testing.T) {
+	Convey("template with known fields, should parse", t, func() {
+		_, err := ParseTargetTemplate("instance", `{{ .Hostname }}/{{ or .ComposeService .Name }}:{{ .Port }}`)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("template with label lookup, should parse", t, func() {
+		_, err := ParseTargetTemplate("instance", `{{ .Labels.team }}-{{ index .Labels "com.example.name" }}:{{ .Port }}`)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("template with syntax error, should fail", t, func() {
+		_, err := ParseTargetTemplate("instance", `{{ .Name `)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("template with unknown field, should fail", t, func() {
+		_, err := ParseTargetTemplate("instance", `{{ .ContainerName }}`)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestExtractTemplates(t *testing.T) {
+	log := slog.Default()
+
+	Convey("given compose container in target network", t, func() {
+		c := types.Container{
+			ID:    "containerID",
+			Names: []string{"/shop-app-1"},
+			Labels: map[string]string{
+				"prometheus_job":                      "job1",
+				"com.docker.compose.project":          "shop",
+				"com.docker.compose.service":          "app",
+				"com.docker.compose.container-number": "1",
+				"team":                                "a"},
+			Ports: []types.Port{{Type: "tcp", PrivatePort: 8080}},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"metrics-net": {IPAddress: "ip1"}}}}
+		conf := &Config{
+			InstancePrefix: "host1/",
+			ExternalHost:   "host1.example",
+			TargetNetworks: TargetNetworks{Names: []string{"metrics-net"}}}
+
+		Convey("with instance template", func() {
+			tmpl, err := ParseTargetTemplate("instance", `{{ .Hostname }}/{{ .ComposeService }}-{{ .ComposeNumber }}:{{ .Port }}`)
+			So(err, ShouldBeNil)
+			conf.InstanceTemplate = tmpl
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should have the rendered instance", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].Labels[model.InstanceLabel], ShouldEqual, "host1.example/app-1:8080")
+				So(xs[0].IsExported(), ShouldBeTrue)
+			})
+		})
+
+		Convey("with instance template, that renders empty", func() {
+			tmpl, err := ParseTargetTemplate("instance", `{{ .Labels.owner }}`)
+			So(err, ShouldBeNil)
+			conf.InstanceTemplate = tmpl
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should not be exported, with template error", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].IsExported(), ShouldBeFalse)
+				So(xs[0].TemplateError, ShouldContainSubstring, "rendered empty")
+			})
+		})
+
+		Convey("with external address template and external scrape", func() {
+			c.Labels["prometheus_scrape_external"] = "true"
+			c.Ports = []types.Port{{Type: "tcp", PrivatePort: 8080, PublicPort: 18080}}
+			tmpl, err := ParseTargetTemplate("external-address", `{{ .Labels.team }}.{{ .Hostname }}:{{ .Port }}`)
+			So(err, ShouldBeNil)
+			conf.ExternalAddressTemplate = tmpl
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should have the rendered address", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].Address, ShouldEqual, "a.host1.example:8080")
+				So(xs[0].Labels[model.AddressLabel], ShouldEqual, "a.host1.example:8080")
+			})
+
+			Convey("should keep the default instance", func() {
+				So(xs[0].Labels[model.InstanceLabel], ShouldEqual, "host1//shop-app-1:8080")
+			})
+		})
+
+		Convey("with external address template without port", func() {
+			c.Labels["prometheus_scrape_external"] = "true"
+			tmpl, err := ParseTargetTemplate("external-address", `{{ .Hostname }}`)
+			So(err, ShouldBeNil)
+			conf.ExternalAddressTemplate = tmpl
+			xs := extract(log, conf, []types.Container{c}, nil)
+
+			Convey("should not be exported, with template error", func() {
+				So(xs, ShouldHaveLength, 1)
+				So(xs[0].IsExported(), ShouldBeFalse)
+				So(xs[0].TemplateError, ShouldContainSubstring, "invalid address")
+			})
+		})
+	})
+}
@@ -0,0 +1,61 @@
+package docker
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/common/config"
+)
+
+// environment variables of the docker CLI
+const (
+	envDockerHost      = "DOCKER_HOST"
+	envDockerTLSVerify = "DOCKER_TLS_VERIFY"
+	envDockerCertPath  = "DOCKER_CERT_PATH"
+
+	defaultDockerHost = "unix:///var/run/docker.sock"
+)
+
+// TLSConfigFromEnv returns the TLS settings with the semantics of the docker
+// CLI. The CA, client certificate and key are ca.pem, cert.pem and key.pem in
+// DOCKER_CERT_PATH (~/.docker when only DOCKER_TLS_VERIFY is set). The server
+// certificate is only verified when DOCKER_TLS_VERIFY is set. Returns false
+// when neither is set
+func TLSConfigFromEnv() (config.TLSConfig, bool) {
+	verify := os.Getenv(envDockerTLSVerify) != ""
+	certPath := os.Getenv(envDockerCertPath)
+	if certPath == "" && !verify {
+		return config.TLSConfig{}, false
+	}
+
+	if certPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return config.TLSConfig{}, false
+		}
+		certPath = filepath.Join(home, ".docker")
+	}
+
+	return config.TLSConfig{
+		CAFile:             filepath.Join(certPath, "ca.pem"),
+		CertFile:           filepath.Join(certPath, "cert.pem"),
+		KeyFile:            filepath.Join(certPath, "key.pem"),
+		InsecureSkipVerify: !verify}, true
+}
+
+// scheme of the HTTP client for the Docker host, if HTTP based. As for the
+// docker CLI, tcp:// is https when TLS is configured, otherwise http
+func httpScheme(hostURL *url.URL, tlsConfig config.TLSConfig) (string, bool) {
+	switch hostURL.Scheme {
+	case "http", "https":
+		return hostURL.Scheme, true
+	case "tcp":
+		if tlsConfig != (config.TLSConfig{}) {
+			return "https", true
+		}
+		return "http", true
+	default:
+		return "", false
+	}
+}
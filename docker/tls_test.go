@@ -0,0 +1,206 @@
+package docker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/prometheus/common/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fake Docker API over TLS, with a single container in metrics-net
+func tlsServer(clientCAs *x509.CertPool) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Api-Version", "1.45")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			_ = json.NewEncoder(w).Encode([]types.Container{{
+				ID:     "c1",
+				Names:  []string{"/app"},
+				Labels: map[string]string{defaultKeys.job: "job1"},
+				Ports:  []types.Port{{Type: "tcp", PrivatePort: 8080}},
+				NetworkSettings: &types.SummaryNetworkSettings{
+					Networks: map[string]*network.EndpointSettings{
+						"metrics-net": {NetworkID: "n1", IPAddress: "10.0.0.2"}}}}})
+		case strings.HasSuffix(r.URL.Path, "/networks"):
+			_ = json.NewEncoder(w).Encode([]network.Summary{{ID: "n1", Name: "metrics-net"}})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	if clientCAs != nil {
+		server.TLS = &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs}
+	}
+	server.StartTLS()
+	return server
+}
+
+// write PEM block to file in dir
+func writePEM(dir, name, blockType string, bytes []byte) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes}), 0600); err != nil {
+		panic(err)
+	}
+	return path
+}
+
+// self-signed client certificate. Returns the cert and key files, and the pool to verify it
+func clientCertificate(dir string) (string, string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "prometheus_docker_sd"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return writePEM(dir, "cert.pem", "CERTIFICATE", der), writePEM(dir, "key.pem", "EC PRIVATE KEY", keyDER), pool
+}
+
+func TestTLS(t *testing.T) {
+	refresh := func(tlsConfig config.TLSConfig, host string) ([]Meta, error) {
+		d, err := New(&Config{
+			HTTPClientConfig: config.HTTPClientConfig{TLSConfig: tlsConfig},
+			DockerHost:       host,
+			InstancePrefix:   "host1",
+			TargetNetworks:   TargetNetworks{Names: []string{"metrics-net"}},
+			RefreshInterval:  5 * time.Second})
+		if err != nil {
+			return nil, err
+		}
+		return d.Refresh(context.Background())
+	}
+
+	Convey("given Docker API over TLS", t, func() {
+		dir := t.TempDir()
+		server := tlsServer(nil)
+		defer server.Close()
+		caFile := writePEM(dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+		host := "tcp://" + server.Listener.Addr().String()
+
+		Convey("with CA file, tcp:// host should be https", func() {
+			xs, err := refresh(config.TLSConfig{CAFile: caFile}, host)
+			So(err, ShouldBeNil)
+			So(xs, ShouldHaveLength, 1)
+			So(xs[0].Address, ShouldEqual, "10.0.0.2:8080")
+			So(xs[0].Labels[dockerLabelHost], ShouldEqual, host)
+		})
+
+		Convey("with CA file and https:// host, should succeed", func() {
+			_, err := refresh(config.TLSConfig{CAFile: caFile}, server.URL)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("with server name in certificate, should succeed", func() {
+			_, err := refresh(config.TLSConfig{CAFile: caFile, ServerName: "example.com"}, host)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("with server name not in certificate, should fail", func() {
+			_, err := refresh(config.TLSConfig{CAFile: caFile, ServerName: "other.com"}, host)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("without CA file, should fail to verify", func() {
+			_, err := refresh(config.TLSConfig{}, server.URL)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("with insecure skip verify, should succeed", func() {
+			_, err := refresh(config.TLSConfig{InsecureSkipVerify: true}, host)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("given Docker API over TLS, requiring a client certificate", t, func() {
+		dir := t.TempDir()
+		certFile, keyFile, pool := clientCertificate(dir)
+		server := tlsServer(pool)
+		defer server.Close()
+		caFile := writePEM(dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+		host := "tcp://" + server.Listener.Addr().String()
+
+		Convey("with client certificate, should succeed", func() {
+			xs, err := refresh(config.TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, host)
+			So(err, ShouldBeNil)
+			So(xs, ShouldHaveLength, 1)
+		})
+
+		Convey("without client certificate, should fail", func() {
+			_, err := refresh(config.TLSConfig{CAFile: caFile}, host)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestTLSConfigFromEnv(t *testing.T) {
+	Convey("given DOCKER_CERT_PATH", t, func() {
+		t.Setenv(envDockerCertPath, "/certs")
+		t.Setenv(envDockerTLSVerify, "")
+
+		Convey("should use the files in the path, without verifying the server", func() {
+			c, ok := TLSConfigFromEnv()
+			So(ok, ShouldBeTrue)
+			So(c.CAFile, ShouldEqual, "/certs/ca.pem")
+			So(c.CertFile, ShouldEqual, "/certs/cert.pem")
+			So(c.KeyFile, ShouldEqual, "/certs/key.pem")
+			So(c.InsecureSkipVerify, ShouldBeTrue)
+		})
+
+		Convey("and DOCKER_TLS_VERIFY, should verify the server", func() {
+			t.Setenv(envDockerTLSVerify, "1")
+			c, ok := TLSConfigFromEnv()
+			So(ok, ShouldBeTrue)
+			So(c.InsecureSkipVerify, ShouldBeFalse)
+		})
+	})
+
+	Convey("given neither DOCKER_CERT_PATH nor DOCKER_TLS_VERIFY", t, func() {
+		t.Setenv(envDockerCertPath, "")
+		t.Setenv(envDockerTLSVerify, "")
+
+		Convey("should not configure TLS", func() {
+			_, ok := TLSConfigFromEnv()
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -6,9 +6,12 @@ import (
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/bredtape/prometheus_docker_sd/docker"
@@ -18,6 +21,9 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
 	"gopkg.in/yaml.v3"
 )
 
@@ -26,10 +32,29 @@ const (
 )
 
 var (
-	outputFile, httpAddress, externalUrl string
+	outputFile, probeOutputFile, httpAddress, externalUrl string
 )
 
-func parseArgs() *docker.Config {
+// hosts file, to discover from several Docker hosts in one process. The
+// flags are defaults for all hosts
+type hostsFile struct {
+	Hosts []hostConfig `yaml:"hosts"`
+}
+
+type hostConfig struct {
+	HTTPClientConfig   config.HTTPClientConfig `yaml:",inline"`
+	DockerHost         string                  `yaml:"host"`
+	InstancePrefix     string                  `yaml:"instance_prefix"`
+	ExternalHost       string                  `yaml:"external_host"`
+	HostNetworkAddress string                  `yaml:"host_network_address"`
+	TargetNetworkNames []string                `yaml:"target_network_names"`
+	TargetNetworkRegex string                  `yaml:"target_network_regex"`
+
+	// where the host was resolved from
+	source string
+}
+
+func parseArgs() ([]*docker.Config, []docker.Endpoint) {
 	envPrefix := strings.ToUpper(APP)
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	fs.Usage = func() {
@@ -38,16 +63,52 @@ func parseArgs() *docker.Config {
 		os.Exit(1)
 	}
 
-	var dockerHost, instancePrefix, externalHost, targetNetworkName string
-	var refreshInterval time.Duration
+	var dockerHost, dockerContext, instancePrefix, externalHost, hostNetworkAddress, targetNetworkName, targetNetworkRegex, addressFamily string
+	var mode, labelPrefix, labelInclude, labelExclude, instanceNaming, excludeStates, excludeHealth, noAddressPolicy, configFile, relabelConfigFile string
+	var tlsCAFile, tlsCertFile, tlsKeyFile, tlsServerName string
+	var instanceTemplate, externalAddressTemplate string
+	var refreshInterval, eventDebounce, gracePeriod time.Duration
+	var watchEvents, tlsInsecureSkipVerify, prometheusIO, optOut bool
+	var prometheusIOJob, jobSource, probeAddress, probeJob string
 	fs.StringVar(&outputFile, "output-file", "docker_sd.yml", "Output .json, .yml or .yaml file with format as specified in https://prometheus.io/docs/prometheus/latest/configuration/configuration/#file_sd_config")
-	fs.StringVar(&dockerHost, "docker-host", "unix:///var/run/docker.sock", "Docker host URL. Only socket have been tested.")
-	fs.StringVar(&targetNetworkName, "target-network-name", "metrics-net", "Network that the containers must be a member of to be considered. Consider making it 'external' in the docker-compose...")
-	fs.StringVar(&instancePrefix, "instance-prefix", "", "Prefix added to Container name to form the 'instance' label. Required")
+	fs.StringVar(&probeOutputFile, "probe-output-file", "", "Optional output file for the blackbox exporter probe targets, with the format of 'output-file'. Defaults to writing them to 'output-file', alongside the scrape targets")
+	fs.StringVar(&configFile, "config-file", "", "Optional YAML file with a list of Docker 'hosts' to discover from, each with 'host' and 'instance_prefix' (required), 'external_host', 'host_network_address', 'target_network_names', 'target_network_regex' and HTTP client settings. Replaces 'docker-host', 'docker-context', 'instance-prefix', 'external-host', 'host-network-address' and the 'tls-*' flags. The other flags apply to all hosts, and the target network flags are the default")
+	fs.StringVar(&mode, "mode", string(docker.ModeContainers), "Discovery mode. 'containers' of a single host, or 'swarm' to discover the tasks of swarm services (must be a manager node), 1 target per task")
+	fs.StringVar(&dockerHost, "docker-host", "", "Docker host URL, e.g. unix:///var/run/docker.sock, tcp://host:2376 or ssh://user@host. Defaults to DOCKER_HOST, the docker context or the rootless socket in XDG_RUNTIME_DIR (if present), otherwise unix:///var/run/docker.sock")
+	fs.StringVar(&dockerContext, "docker-context", "", "Name of the docker CLI context to resolve the Docker host and TLS files from, in ~/.docker/contexts. Defaults to DOCKER_CONTEXT or the current context, if 'docker-host' and DOCKER_HOST are not set")
+	fs.StringVar(&tlsCAFile, "tls-ca-file", "", "CA certificate file to verify the Docker host with. A tcp:// Docker host is https, when any TLS option is set. Defaults to ca.pem of the docker context or in DOCKER_CERT_PATH")
+	fs.StringVar(&tlsCertFile, "tls-cert-file", "", "Client certificate file to authenticate with the Docker host. Defaults to cert.pem of the docker context or in DOCKER_CERT_PATH")
+	fs.StringVar(&tlsKeyFile, "tls-key-file", "", "Client key file to authenticate with the Docker host. Defaults to key.pem of the docker context or in DOCKER_CERT_PATH")
+	fs.StringVar(&tlsServerName, "tls-server-name", "", "Server name to verify the certificate of the Docker host with, if different from the host name")
+	fs.BoolVar(&tlsInsecureSkipVerify, "tls-insecure-skip-verify", false, "Do not verify the certificate of the Docker host. Implied by the docker context skipping verification, or DOCKER_CERT_PATH set without DOCKER_TLS_VERIFY")
+	fs.StringVar(&targetNetworkName, "target-network-name", "metrics-net", "Comma separated list of networks that the containers must be a member of (at least 1) to be considered. When a container is a member of several, the first in the list is used. Consider making it 'external' in the docker-compose...")
+	fs.StringVar(&targetNetworkRegex, "target-network-regex", "", "Regular expression (anchored) for networks that the containers must be a member of to be considered. Matching networks are used after 'target-network-name', in alphabetical order")
+	fs.StringVar(&addressFamily, "address-family", string(docker.IPv4), "IP address family of the scrape address in the target network. One of ipv4, ipv6 or prefer-ipv6 (IPv6 when available, otherwise IPv4)")
+	fs.StringVar(&instancePrefix, "instance-prefix", "", "Prefix added to Container name to form the 'instance' label. Required, unless 'config-file' is used")
+	fs.StringVar(&instanceNaming, "instance-naming", string(docker.InstanceByContainerName), "How the container is named in the 'instance' label. 'container-name', or 'compose' for <project>/<service>/<container number> of docker compose containers (stable when compose recreates containers), falling back to the container name")
+	fs.StringVar(&instanceTemplate, "instance-template", "", "Go text/template of the 'instance' label, e.g. '{{ .Hostname }}/{{ or .ComposeService .Name }}:{{ .Port }}'. Fields: Name, Endpoint, ComposeProject, ComposeService, ComposeNumber, Hostname, InstancePrefix, Port, IP and Labels (Docker labels of the container). Takes precedence over 'instance-naming'")
 	fs.StringVar(&externalHost, "external-host", "", "External host of this service, defaults to <instance-prefix>, when not specified. Used for external scrape targets")
+	fs.StringVar(&externalAddressTemplate, "external-address-template", "", "Go text/template of the address of targets scraped via the external host, with the fields of 'instance-template'. Defaults to <external-host>:<port>")
+	fs.StringVar(&hostNetworkAddress, "host-network-address", "", "Address of this host, to scrape containers with network_mode host on. Defaults to <external-host>")
+	fs.StringVar(&labelPrefix, "label-prefix", docker.DefaultLabelPrefix, "Prefix of the container labels to react to, e.g. 'promteam_' for 'promteam_job'. Allows several instances with disjoint label sets on the same Docker host")
+	fs.StringVar(&labelInclude, "label-include", "", "Comma separated list of Docker labels of containers and networks to add as '__meta_docker_*_label_*' labels. Each is an exact name, a prefix ending with '*' or a regular expression (anchored) starting with '~'. Defaults to all")
+	fs.StringVar(&labelExclude, "label-exclude", strings.Join(docker.DefaultLabelExcludes, ","), "Comma separated list of Docker labels of containers and networks not to add as '__meta_docker_*_label_*' labels, in the same format as 'label-include'. Defaults to the noisy docker compose internals")
+	fs.BoolVar(&prometheusIO, "prometheus-io-labels", false, "Read the Kubernetes style prometheus.io/scrape, prometheus.io/port, prometheus.io/path and prometheus.io/scheme labels. The '<label-prefix>*' labels take precedence, when both are present")
+	fs.StringVar(&prometheusIOJob, "prometheus-io-job", "docker", "Job of containers with the label prometheus.io/scrape=true, but without the '<label-prefix>job' label. Requires 'prometheus-io-labels'")
+	fs.StringVar(&probeAddress, "probe-address", "", "Address <host>:<port> of the blackbox exporter, to probe containers with the '<label-prefix>probe_module' label on. Probes are disabled, when not set")
+	fs.StringVar(&probeJob, "probe-job", "blackbox", "Job of the probe targets, unless the '<label-prefix>probe_job' label is set")
+	fs.BoolVar(&optOut, "opt-out", false, "Export all containers in the target networks with a TCP port, not only those with the '<label-prefix>job' label. Containers opt out with '<label-prefix>scrape=false'")
+	fs.StringVar(&jobSource, "job-source", string(docker.JobFromComposeService), "What the job of containers without the '<label-prefix>job' label is derived from, with 'opt-out'. One of 'compose-service' (falls back to the container name), 'image' (name without registry and tag) or 'container-name'")
+	fs.StringVar(&relabelConfigFile, "relabel-config-file", "", "Optional YAML file with 'relabel_configs', in the format of the Prometheus scrape config. The rules are applied to all targets before writing the output file. Dropped targets are still listed on the /containers page")
+	fs.StringVar(&excludeStates, "exclude-states", "", "Comma separated list of container states to exclude from the targets, e.g. 'exited,paused,restarting'. Excluded containers are still listed on the /containers page")
+	fs.StringVar(&excludeHealth, "exclude-health", "", "Comma separated list of healthcheck statuses to exclude from the targets. Any of starting, healthy, unhealthy or none (no healthcheck)")
+	fs.StringVar(&noAddressPolicy, "no-address-policy", string(docker.NoAddressMarker), "Policy for containers in the target network without an IP address, e.g. when restarting. One of 'drop' (not exported), 'last-known' (last known address of the container, otherwise drop) or 'marker' (exported with an address that never resolves, so 'up' is 0)")
+	fs.DurationVar(&gracePeriod, "grace-period", 0, "Period to keep emitting targets of containers, that disappeared or lost their IP address, marked as stale. Avoids gaps in 'up' when a container restarts between refreshes. Zero to disable")
 	fs.DurationVar(&refreshInterval, "refresh-interval", 60*time.Second, "Refresh interval to query the Docker host for containers")
+	fs.BoolVar(&watchEvents, "watch-events", true, "Subscribe to Docker events and refresh when containers start, stop or change networks. The refresh interval is kept as a safety net")
+	fs.DurationVar(&eventDebounce, "event-debounce", 2*time.Second, "Period to collect a burst of Docker events before triggering a refresh")
 	fs.StringVar(&httpAddress, "http-address", ":9200", "http address to serve metrics on")
-	fs.StringVar(&externalUrl, "external-url", "", "External URL of this service, defaults to http://<instance-prefix>:9200. Added to metrics label, so an alert can redirect a user to the /containers page")
+	fs.StringVar(&externalUrl, "external-url", "", "External URL of this service, defaults to http://<instance-prefix>:9200 (of the first host). Added to metrics label, so an alert can redirect a user to the /containers page")
 
 	var logLevel slog.Level
 	fs.TextVar(&logLevel, "log-level", slog.LevelDebug-3, "Log level")
@@ -70,77 +131,372 @@ func parseArgs() *docker.Config {
 	slogging.SetDefaults(slog.HandlerOptions{Level: logLevel}, logJSON)
 	slogging.LogBuildInfo()
 
-	if len(targetNetworkName) == 0 {
-		bail(fs, "'target-network-name' required")
+	discoveryMode, err := docker.ParseMode(mode)
+	if err != nil {
+		bail(fs, "'mode' invalid: %s", err.Error())
+	}
+
+	if err := docker.ValidateLabelPrefix(labelPrefix); err != nil {
+		bail(fs, "'label-prefix' invalid: %s", err.Error())
+	}
+
+	labelFilter, err := docker.NewLabelFilter(splitList(labelInclude), splitList(labelExclude))
+	if err != nil {
+		bail(fs, "'label-include' or 'label-exclude' invalid: %s", err.Error())
+	}
+
+	if prometheusIO && prometheusIOJob == "" {
+		bail(fs, "'prometheus-io-job' required with 'prometheus-io-labels'")
+	}
+
+	if probeAddress != "" {
+		if _, _, err := net.SplitHostPort(probeAddress); err != nil {
+			bail(fs, "'probe-address' invalid: %s", err.Error())
+		}
+		if probeJob == "" {
+			bail(fs, "'probe-job' required with 'probe-address'")
+		}
+	}
+
+	source, err := docker.ParseJobSource(jobSource)
+	if err != nil {
+		bail(fs, "'job-source' invalid: %s", err.Error())
+	}
+
+	naming, err := docker.ParseInstanceNaming(instanceNaming)
+	if err != nil {
+		bail(fs, "'instance-naming' invalid: %s", err.Error())
+	}
+
+	var instanceTmpl, externalAddressTmpl *template.Template
+	if instanceTemplate != "" {
+		instanceTmpl, err = docker.ParseTargetTemplate("instance", instanceTemplate)
+		if err != nil {
+			bail(fs, "'instance-template' invalid: %s", err.Error())
+		}
+	}
+	if externalAddressTemplate != "" {
+		externalAddressTmpl, err = docker.ParseTargetTemplate("external-address", externalAddressTemplate)
+		if err != nil {
+			bail(fs, "'external-address-template' invalid: %s", err.Error())
+		}
+	}
+
+	family, err := docker.ParseAddressFamily(addressFamily)
+	if err != nil {
+		bail(fs, "'address-family' invalid: %s", err.Error())
+	}
+
+	policy := docker.ExportPolicy{
+		ExcludeStates: splitList(excludeStates),
+		ExcludeHealth: splitList(excludeHealth)}
+	if err := policy.Validate(); err != nil {
+		bail(fs, "'exclude-states' or 'exclude-health' invalid: %s", err.Error())
+	}
+
+	noAddress, err := docker.ParseNoAddressPolicy(noAddressPolicy)
+	if err != nil {
+		bail(fs, "'no-address-policy' invalid: %s", err.Error())
+	}
+
+	var relabelConfigs []*relabel.Config
+	if relabelConfigFile != "" {
+		relabelConfigs, err = docker.LoadRelabelConfigs(relabelConfigFile)
+		if err != nil {
+			bail(fs, "'relabel-config-file' invalid: %s", err.Error())
+		}
 	}
 
-	if len(instancePrefix) == 0 {
-		bail(fs, "'instance-prefix' required")
+	var hosts []hostConfig
+	if configFile != "" {
+		hosts, err = readHostsFile(configFile)
+		if err != nil {
+			bail(fs, "'config-file' invalid: %s", err.Error())
+		}
+		for i := range hosts {
+			hosts[i].source = "config-file"
+		}
+	} else {
+		endpoint, err := docker.ResolveEndpoint(dockerHost, dockerContext)
+		if err != nil {
+			bail(fs, "failed to resolve docker host: %s", err.Error())
+		}
+
+		// the flags take precedence over the endpoint
+		tlsConfig := endpoint.TLSConfig
+		tlsConfig.CAFile = firstNonEmpty(tlsCAFile, tlsConfig.CAFile)
+		tlsConfig.CertFile = firstNonEmpty(tlsCertFile, tlsConfig.CertFile)
+		tlsConfig.KeyFile = firstNonEmpty(tlsKeyFile, tlsConfig.KeyFile)
+		tlsConfig.ServerName = firstNonEmpty(tlsServerName, tlsConfig.ServerName)
+		tlsConfig.InsecureSkipVerify = tlsInsecureSkipVerify || tlsConfig.InsecureSkipVerify
+
+		hosts = []hostConfig{{
+			HTTPClientConfig:   config.HTTPClientConfig{TLSConfig: tlsConfig},
+			DockerHost:         endpoint.Host,
+			InstancePrefix:     instancePrefix,
+			ExternalHost:       externalHost,
+			HostNetworkAddress: hostNetworkAddress,
+			source:             endpoint.Source}}
+	}
+
+	result := make([]*docker.Config, 0, len(hosts))
+	endpoints := make([]docker.Endpoint, 0, len(hosts))
+	seen := make(map[string]struct{}, len(hosts))
+	for _, h := range hosts {
+		if h.DockerHost == "" {
+			bail(fs, "'host' required for all hosts in 'config-file'")
+		}
+		// target networks default to the flags
+		if len(h.TargetNetworkNames) == 0 && h.TargetNetworkRegex == "" {
+			h.TargetNetworkNames = splitList(targetNetworkName)
+			h.TargetNetworkRegex = targetNetworkRegex
+		}
+
+		if _, exists := seen[h.DockerHost]; exists {
+			bail(fs, "docker host '%s' listed more than once", h.DockerHost)
+		}
+		seen[h.DockerHost] = struct{}{}
+
+		targetNetworks, err := docker.NewTargetNetworks(h.TargetNetworkNames, h.TargetNetworkRegex)
+		if err != nil {
+			bail(fs, "'target-network-regex' of host '%s' invalid: %s", h.DockerHost, err.Error())
+		}
+		if targetNetworks.IsEmpty() {
+			bail(fs, "'target-network-name' or 'target-network-regex' required")
+		}
+
+		if len(h.InstancePrefix) == 0 {
+			bail(fs, "'instance-prefix' of host '%s' required", h.DockerHost)
+		}
+
+		if h.ExternalHost == "" {
+			h.ExternalHost = h.InstancePrefix
+		}
+		if h.HostNetworkAddress == "" {
+			h.HostNetworkAddress = h.ExternalHost
+		}
+
+		if err := h.HTTPClientConfig.Validate(); err != nil {
+			bail(fs, "http client settings of host '%s' invalid: %s", h.DockerHost, err.Error())
+		}
+
+		result = append(result, &docker.Config{
+			Mode:                    discoveryMode,
+			HTTPClientConfig:        h.HTTPClientConfig,
+			DockerHost:              h.DockerHost,
+			InstancePrefix:          h.InstancePrefix,
+			LabelPrefix:             labelPrefix,
+			LabelFilter:             labelFilter,
+			InstanceNaming:          naming,
+			InstanceTemplate:        instanceTmpl,
+			ExternalAddressTemplate: externalAddressTmpl,
+			PrometheusIO:            prometheusIO,
+			PrometheusIOJob:         prometheusIOJob,
+			OptOut:                  optOut,
+			JobSource:               source,
+			ProbeAddress:            probeAddress,
+			ProbeJob:                probeJob,
+			ExternalHost:            h.ExternalHost,
+			HostNetworkAddress:      h.HostNetworkAddress,
+			TargetNetworks:          targetNetworks,
+			AddressFamily:           family,
+			ExportPolicy:            policy,
+			NoAddressPolicy:         noAddress,
+			GracePeriod:             gracePeriod,
+			RelabelConfigs:          relabelConfigs,
+			RefreshInterval:         refreshInterval,
+			WatchEvents:             watchEvents,
+			EventDebounce:           eventDebounce})
+		endpoints = append(endpoints, docker.Endpoint{
+			Host:      h.DockerHost,
+			TLSConfig: h.HTTPClientConfig.TLSConfig,
+			Source:    h.source})
 	}
 
 	if externalUrl == "" {
-		externalUrl = "http://" + instancePrefix + ":9200"
+		externalUrl = "http://" + result[0].InstancePrefix + ":9200"
+	}
+	return result, endpoints
+}
+
+func readHostsFile(path string) ([]hostConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
-	if externalHost == "" {
-		externalHost = instancePrefix
+
+	var f hostsFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal")
+	}
+	if len(f.Hosts) == 0 {
+		return nil, errors.New("no hosts listed")
 	}
+	return f.Hosts, nil
+}
 
-	return &docker.Config{
-		DockerHost:      dockerHost,
-		InstancePrefix:  instancePrefix,
-		ExternalHost:    externalHost,
-		TargetNetwork:   targetNetworkName,
-		RefreshInterval: refreshInterval}
+// discovery of a single Docker host
+type host struct {
+	config    *docker.Config
+	discovery *docker.Discovery
+	// result of the last successful refresh. Kept when a refresh fails, so the
+	// other hosts can still update the output
+	metas []docker.Meta
+
+	targetNetwork                       string
+	mAttempts, mErrors, mEventRefreshes prometheus.Counter
+	// label values of the info series of the last refresh, to remove those of containers gone
+	info map[string][]string
+	// refresh in progress, and whether another was requested meanwhile
+	refreshing, pending bool
+}
+
+// result of the refresh of a host
+type refreshResult struct {
+	host  *host
+	metas []docker.Meta
+	err   error
+}
+
+// refresh the host in the background, so a slow host does not hold back the
+// others. Only 1 refresh per host is in progress, another is run after it, if
+// requested meanwhile. The result is sent to done
+func (h *host) startRefresh(ctx context.Context, done chan<- refreshResult) {
+	if h.refreshing {
+		h.pending = true
+		return
+	}
+	h.refreshing = true
+	h.mAttempts.Inc()
+	go func() {
+		xs, err := h.discovery.Refresh(ctx)
+		done <- refreshResult{host: h, metas: xs, err: err}
+	}()
+}
+
+// update the host with the result of the refresh. A failing host keeps its last result
+func (h *host) update(log *slog.Logger, r refreshResult) {
+	h.refreshing = false
+	if r.err != nil {
+		h.mErrors.Inc()
+		log.Error("failed to refresh containers, keeping last result", "dockerHost", h.config.DockerHost, "error", r.err)
+		return
+	}
+	h.metas = r.metas
+	updateMetrics(externalUrl, h.targetNetwork, h.config.DockerHost, r.metas)
+	h.updateInfo(r.metas)
+}
+
+// set the info series of the containers with job, and remove the series of
+// containers that went away or changed
+func (h *host) updateInfo(xs []docker.Meta) {
+	current := make(map[string][]string, len(xs))
+	for _, x := range xs {
+		if !x.HasJob || x.IsProbe {
+			continue
+		}
+		values := []string{externalUrl, h.targetNetwork, h.config.DockerHost,
+			x.Name, x.Endpoint, x.Labels[model.JobLabel], strconv.FormatBool(x.IsExported()), x.State, x.Reason()}
+		current[strings.Join(values, "\xff")] = values
+		metric_container_info.WithLabelValues(values...).Set(1)
+	}
+
+	for key, values := range h.info {
+		if _, exists := current[key]; !exists {
+			metric_container_info.DeleteLabelValues(values...)
+		}
+	}
+	h.info = current
 }
 
 func main() {
 	ctx := context.Background()
-	config := parseArgs()
+	configs, endpoints := parseArgs()
+	labelPrefix := configs[0].LabelPrefix
+	initMetrics(labelPrefix)
 	log := slog.Default()
+	for _, e := range endpoints {
+		log.Info("resolved docker host", "dockerHost", e.Host, "source", e.Source)
+	}
 
 	updates := make(chan []docker.Meta, 1)
 	log.Info("starting http handler", "address", httpAddress)
-	go web.Serve(httpAddress, updates)
+	go web.Serve(httpAddress, labelPrefix, endpoints, updates)
 
-	d, err := docker.New(config)
-	if err != nil {
-		log.Error("failed to configure discovery", "error", err)
-		os.Exit(4)
-	}
+	// index of the host, that received events
+	events := make(chan int, len(configs))
+	hosts := make([]*host, 0, len(configs))
+	for i, conf := range configs {
+		d, err := docker.New(conf)
+		if err != nil {
+			log.Error("failed to configure discovery", "dockerHost", conf.DockerHost, "error", err)
+			os.Exit(4)
+		}
 
-	// init metrics
-	mAttempts := metric_attempts.WithLabelValues(externalUrl, config.TargetNetwork)
-	mErrors := metric_errors.WithLabelValues(externalUrl, config.TargetNetwork)
+		// init metrics
+		// all target networks are represented by a single label value, e.g. 'net1,net2,~team-.*'
+		targetNetwork := conf.TargetNetworks.String()
+		hosts = append(hosts, &host{
+			config:          conf,
+			discovery:       d,
+			targetNetwork:   targetNetwork,
+			mAttempts:       metric_attempts.WithLabelValues(externalUrl, targetNetwork, conf.DockerHost),
+			mErrors:         metric_errors.WithLabelValues(externalUrl, targetNetwork, conf.DockerHost),
+			mEventRefreshes: metric_event_refreshes.WithLabelValues(externalUrl, targetNetwork, conf.DockerHost)})
+
+		if conf.WatchEvents {
+			trigger := make(chan struct{}, 1)
+			go d.Watch(ctx, trigger)
+			go func() {
+				for range trigger {
+					events <- i
+				}
+			}()
+		}
+	}
 
+	// the refresh interval is the same for all hosts
+	refreshInterval := configs[0].RefreshInterval
 	t := time.After(0)
 	log = log.With("context", "main")
+	// at most 1 refresh per host is in progress, so the sends never block
+	done := make(chan refreshResult, len(hosts))
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-t:
-			mAttempts.Inc()
-
-			// refresh timer
-			t = time.After(config.RefreshInterval)
+			// refresh timer, the periodic poll is kept as a safety net for missed events
+			t = time.After(refreshInterval)
+			log.Info("begin refresh", "hosts", len(hosts))
+			for _, h := range hosts {
+				h.startRefresh(ctx, done)
+			}
+		case i := <-events:
+			hosts[i].mEventRefreshes.Inc()
+			log.Debug("refresh triggered by events", "dockerHost", hosts[i].config.DockerHost)
+			hosts[i].startRefresh(ctx, done)
+		case r := <-done:
+			r.host.update(log, r)
+			if r.host.pending {
+				r.host.pending = false
+				r.host.startRefresh(ctx, done)
+			}
 
-			log.Info("begin refresh")
-			xs, err := d.Refresh(ctx)
-			if err != nil {
-				mErrors.Inc()
-				log.Error("failed to refresh containers", "error", err)
-				continue
+			// write the output when any host is done, with the last result of the others
+			xs := make([]docker.Meta, 0)
+			for _, h := range hosts {
+				xs = append(xs, h.metas...)
 			}
 
-			err = writeResultsToFile(outputFile, convert(xs))
+			err := writeResults(xs)
 			if err != nil {
-				mErrors.Inc()
+				r.host.mErrors.Inc()
 				log.Error("failed to write results", "error", err)
 				continue
 			}
-			updateMetrics(externalUrl, config.TargetNetwork, xs)
 			updates <- xs
-			log.Debug("done refresh")
+			log.Debug("done refresh", "dockerHost", r.host.config.DockerHost)
 		}
 	}
 }
@@ -150,6 +506,26 @@ type Export struct {
 	Labels  map[string]string `yaml:"labels"`
 }
 
+// write the targets to the output file. The probes to their own file, if configured
+func writeResults(xs []docker.Meta) error {
+	if probeOutputFile == "" {
+		return writeResultsToFile(outputFile, convert(xs))
+	}
+
+	targets, probes := make([]docker.Meta, 0, len(xs)), make([]docker.Meta, 0)
+	for _, x := range xs {
+		if x.IsProbe {
+			probes = append(probes, x)
+		} else {
+			targets = append(targets, x)
+		}
+	}
+	if err := writeResultsToFile(outputFile, convert(targets)); err != nil {
+		return err
+	}
+	return writeResultsToFile(probeOutputFile, convert(probes))
+}
+
 func writeResultsToFile(outputFile string, xs []Export) error {
 	switch filepath.Ext(strings.ToLower(outputFile)) {
 	case ".yml", ".yaml":
@@ -182,15 +558,60 @@ func convert(xs []docker.Meta) []Export {
 	return ys
 }
 
+func firstNonEmpty(xs ...string) string {
+	for _, x := range xs {
+		if x != "" {
+			return x
+		}
+	}
+	return ""
+}
+
+// split comma separated list, ignoring empty entries
+func splitList(s string) []string {
+	result := make([]string, 0)
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			result = append(result, x)
+		}
+	}
+	return result
+}
+
 func bail(fs *flag.FlagSet, format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, format, args...)
 	fs.Usage()
 	os.Exit(3)
 }
 
+var labelKeys = []string{"external_url", "target_network", "docker_host"}
+
 var (
-	labelKeys = []string{"external_url", "target_network"}
+	metric_attempts                          *prometheus.CounterVec
+	metric_errors                            *prometheus.CounterVec
+	metric_event_refreshes                   *prometheus.CounterVec
+	metric_count                             *prometheus.GaugeVec
+	metric_endpoints                         *prometheus.GaugeVec
+	metric_ignored                           *prometheus.GaugeVec
+	metric_excluded                          *prometheus.GaugeVec
+	metric_ignored_containers_not_in_network *prometheus.GaugeVec
+	metric_invalid_scrape_network            *prometheus.GaugeVec
+	metric_host_network                      *prometheus.GaugeVec
+	metric_ignored_no_ports                  *prometheus.GaugeVec
+	metric_no_ip_address                     *prometheus.GaugeVec
+	metric_no_address_in_family              *prometheus.GaugeVec
+	metric_in_grace                          *prometheus.GaugeVec
+	metric_multiple_ports                    *prometheus.GaugeVec
+	metric_relabel_dropped                   *prometheus.GaugeVec
+	metric_template_errors                   *prometheus.GaugeVec
+	metric_derived_job                       *prometheus.GaugeVec
+	metric_problems                          *prometheus.GaugeVec
+	metric_probes                            *prometheus.GaugeVec
+	metric_container_info                    *prometheus.GaugeVec
+)
 
+// create the metrics. The help texts refer to the labels of the configured prefix
+func initMetrics(labelPrefix string) {
 	metric_attempts = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: APP,
 		Name:      "discovery_attempts_total",
@@ -201,44 +622,173 @@ var (
 		Name:      "discovery_attempts_errors_total",
 		Help:      "Number of attempts to discover containers and write result, that resulted in some error"}, labelKeys)
 
+	metric_event_refreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: APP,
+		Name:      "discovery_event_refreshes_total",
+		Help:      "Number of refreshes triggered by Docker events, rather than the refresh interval"}, labelKeys)
+
 	metric_count = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: APP,
 		Name:      "containers_count",
 		Help:      "Number of containers discovered"},
 		labelKeys)
 
+	metric_endpoints = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "endpoints_count",
+		Help:      "Number of endpoints discovered for containers with the '" + labelPrefix + "job' label or named endpoints. A container may have multiple endpoints"},
+		labelKeys)
+
 	metric_ignored = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: APP,
 		Name:      "containers_ignored_count",
 		Help:      "Number of containers discovered that were ignored"},
 		labelKeys)
 
+	metric_excluded = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "containers_excluded_count",
+		Help:      "Number of containers discovered with the '" + labelPrefix + "job' label set, but excluded by container state or health status"},
+		labelKeys)
+
 	metric_ignored_containers_not_in_network = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: APP,
 		Name:      "containers_not_in_target_network_count",
-		Help:      "Number of containers discovered with the 'prometheus_job' label set, but not in the target network"},
+		Help:      "Number of containers discovered with the '" + labelPrefix + "job' label set, but not in the target network"},
+		labelKeys)
+
+	metric_invalid_scrape_network = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "containers_invalid_scrape_network_count",
+		Help:      "Number of containers discovered with the '" + labelPrefix + "job' label set, with the '" + labelPrefix + "scrape_network' label set to a network the container is not a member of"},
+		labelKeys)
+
+	metric_host_network = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "containers_host_network_count",
+		Help:      "Number of containers discovered with the '" + labelPrefix + "job' label set, with network_mode host. These are scraped on the host address, and require the '" + labelPrefix + "scrape_port' label"},
 		labelKeys)
 
 	metric_ignored_no_ports = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: APP,
 		Name:      "containers_no_exposed_ports_count",
-		Help:      "Number of containers discovered with the 'prometheus_job' label set, but with no exposed TCP ports"},
+		Help:      "Number of containers discovered with the '" + labelPrefix + "job' label set, but with no exposed TCP ports"},
+		labelKeys)
+
+	metric_no_ip_address = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "containers_no_ip_address_count",
+		Help:      "Number of containers discovered with the '" + labelPrefix + "job' label set, in the target network, but without an IP address, e.g. when restarting"},
+		labelKeys)
+
+	metric_no_address_in_family = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "containers_no_address_in_family_count",
+		Help:      "Number of containers discovered with the '" + labelPrefix + "job' label set, in the target network, but without an IP address of the address family, e.g. IPv6-only with address family ipv4"},
+		labelKeys)
+
+	metric_in_grace = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "targets_in_grace_count",
+		Help:      "Number of targets of containers, that disappeared or lost their IP address, but are still exported in the grace period"},
 		labelKeys)
 
 	metric_multiple_ports = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: APP,
 		Name:      "containers_multiple_ports_not_explicit_count",
-		Help:      "Number of containers discovered with the 'prometheus_job' label set, with multiple exposed TCP ports, but the prometheus_scrape_port is not defined"},
+		Help:      "Number of containers discovered with the '" + labelPrefix + "job' label set, with multiple exposed TCP ports, but the '" + labelPrefix + "scrape_port' is not defined"},
 		labelKeys)
-)
 
-func updateMetrics(externalUrl, targetNetwork string, xs []docker.Meta) {
-	var ignored, notInNetwork, noPorts, notExplicit float64
+	metric_relabel_dropped = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "targets_relabel_dropped_count",
+		Help:      "Number of targets dropped by the relabel rules of 'relabel-config-file'"},
+		labelKeys)
+
+	metric_template_errors = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "targets_template_errors_count",
+		Help:      "Number of targets, that failed to render the 'instance-template' or 'external-address-template'"},
+		labelKeys)
+
+	metric_derived_job = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "endpoints_derived_job_count",
+		Help:      "Number of endpoints discovered without the '" + labelPrefix + "job' label, with the job derived in opt-out mode"},
+		labelKeys)
+
+	metric_problems = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "endpoints_problems_count",
+		Help:      "Number of endpoints discovered with a job, with a problem of the reason, e.g. invalid_port. Endpoints with problems other than port_not_exposed and invalid_param_name are not exported"},
+		append(labelKeys, "reason"))
+
+	metric_probes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "probes_count",
+		Help:      "Number of blackbox exporter probe targets of containers with the '" + labelPrefix + "probe_module' label"},
+		labelKeys)
+
+	metric_container_info = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: APP,
+		Name:      "container_info",
+		Help:      "Info of each endpoint of containers with a job, always 1. 'status' is the container state, 'reason' why the endpoint is not exported (or exported with a warning), e.g. not_in_target_network. Removed when the container goes away"},
+		append(labelKeys, "container", "endpoint", "job", "exported", "status", "reason"))
+}
+
+func updateMetrics(externalUrl, targetNetwork, dockerHost string, xs []docker.Meta) {
+	var endpoints, probes, derivedJob, ignored, excluded, inGrace, relabelDropped, templateErrors, invalidNetwork, hostNetwork, notInNetwork, noPorts, noAddress, noAddressInFamily, notExplicit float64
+	problems := map[docker.ProblemReason]float64{}
+	containers := map[string]struct{}{}
 	for _, x := range xs {
+		containers[x.ID] = struct{}{}
 		if !x.HasJob {
 			ignored++
 			continue
 		}
+		endpoints++
+		if x.IsProbe {
+			probes++
+		}
+		if x.JobDerived {
+			derivedJob++
+		}
+		for _, p := range x.Problems {
+			problems[p.Reason]++
+		}
+
+		if x.IsStale {
+			inGrace++
+			continue
+		}
+
+		if x.ExcludedReason != "" {
+			excluded++
+			continue
+		}
+
+		if x.DroppedByRelabelRule > 0 {
+			relabelDropped++
+			continue
+		}
+
+		if x.TemplateError != "" {
+			templateErrors++
+			continue
+		}
+
+		if x.HasInvalidScrapeNetwork {
+			invalidNetwork++
+			continue
+		}
+
+		if x.IsHostNetwork {
+			hostNetwork++
+			if !x.HasTCPPorts {
+				noPorts++
+			}
+			continue
+		}
 
 		if !x.IsInTargetNetwork {
 			notInNetwork++
@@ -250,14 +800,37 @@ func updateMetrics(externalUrl, targetNetwork string, xs []docker.Meta) {
 			continue
 		}
 
+		if x.NoAddressInFamily {
+			noAddressInFamily++
+			continue
+		}
+
+		if x.NoIPAddress {
+			noAddress++
+		}
+
 		if !x.HasExplicitPort {
 			notExplicit++
 		}
 	}
 
-	metric_count.WithLabelValues(externalUrl, targetNetwork).Set(float64(len(xs)))
-	metric_ignored.WithLabelValues(externalUrl, targetNetwork).Set(ignored)
-	metric_ignored_containers_not_in_network.WithLabelValues(externalUrl, targetNetwork).Set(notInNetwork)
-	metric_ignored_no_ports.WithLabelValues(externalUrl, targetNetwork).Set(noPorts)
-	metric_multiple_ports.WithLabelValues(externalUrl, targetNetwork).Set(notExplicit)
+	metric_count.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(float64(len(containers)))
+	metric_endpoints.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(endpoints)
+	metric_ignored.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(ignored)
+	metric_ignored_containers_not_in_network.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(notInNetwork)
+	metric_excluded.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(excluded)
+	metric_invalid_scrape_network.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(invalidNetwork)
+	metric_host_network.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(hostNetwork)
+	metric_ignored_no_ports.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(noPorts)
+	metric_no_ip_address.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(noAddress)
+	metric_no_address_in_family.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(noAddressInFamily)
+	metric_in_grace.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(inGrace)
+	metric_multiple_ports.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(notExplicit)
+	metric_relabel_dropped.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(relabelDropped)
+	metric_template_errors.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(templateErrors)
+	metric_derived_job.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(derivedJob)
+	metric_probes.WithLabelValues(externalUrl, targetNetwork, dockerHost).Set(probes)
+	for _, reason := range docker.ProblemReasons {
+		metric_problems.WithLabelValues(externalUrl, targetNetwork, dockerHost, string(reason)).Set(problems[reason])
+	}
 }
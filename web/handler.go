@@ -16,20 +16,23 @@ import (
 var templates embed.FS
 
 type handler struct {
-	rw      sync.Mutex
-	updates <-chan []docker.Meta
-	view    View
+	rw          sync.Mutex
+	labelPrefix string
+	endpoints   []docker.Endpoint
+	updates     <-chan []docker.Meta
+	view        View
 }
 
-func StartHandler(updates <-chan []docker.Meta) *handler {
-	h := &handler{updates: updates}
+func StartHandler(labelPrefix string, endpoints []docker.Endpoint, updates <-chan []docker.Meta) *handler {
+	h := &handler{labelPrefix: labelPrefix, endpoints: endpoints, updates: updates}
+	h.view = h.convert(nil)
 	go h.update()
 	return h
 }
 
 func (h *handler) update() {
 	for update := range h.updates {
-		view := convert(update)
+		view := h.convert(update)
 		h.rw.Lock()
 		h.view = view
 		h.rw.Unlock()
@@ -49,41 +52,80 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	err = t.Execute(w, h.view)
 	if err != nil {
-		slog.Error("failed to execute template: %v", err)
+		slog.Error("failed to execute template", "error", err)
 	}
 }
 
 type View struct {
-	Total    int
-	WithJob  int
-	OKs      int
-	Errors   int
-	Warnings int
-	Items    []Item
+	LabelPrefix string   // prefix of the container labels, e.g. prometheus_
+	Hosts       []string // resolved Docker hosts
+	Total       int
+	WithJob     int
+	DerivedJob  int // containers with a derived job only, in opt-out mode
+	Probes      int // blackbox exporter probe targets
+	Endpoints   int // endpoints of containers with job
+	OKs         int
+	Errors      int
+	Warnings    int
+	Excluded    int // by state, health or relabel rule
+	Items       []Item
 }
 
 type Item struct {
 	Name              string
+	Endpoint          string
 	Address           string
+	ScrapeURL         string // effective URL, including the URL parameters
 	Labels            []string
 	HasJob            bool
+	JobDerived        bool // in opt-out mode, rather than from the job label
+	IsProbe           bool // blackbox exporter probe
 	IsExported        bool
 	IsInTargetNetwork bool
+	HasInvalidNetwork bool // scrape network label set, but not a member of it
+	IsHostNetwork     bool // network_mode host
+	ExcludedReason    string
+	NoIPAddress       bool // in target network, but without IP address
+	NoAddressInFamily bool // in target network, but without IP address of the address family
+	IsStale           bool // held in the grace period
 	HasTCPPorts       bool // at least 1 TCP port
 	HasExplicitPort   bool // explicit or single port
+	DroppedLabels     int  // by the label filter
+	// 1-based index of the relabel rule, that dropped the target
+	DroppedByRelabelRule int
+	TemplateError        string // failed to render the instance or address template
+	Problems             []docker.Problem
 }
 
-func convert(xs []docker.Meta) View {
+func (h *handler) convert(xs []docker.Meta) View {
 	view := View{
-		Total: len(xs),
-		Items: make([]Item, 0, len(xs))}
+		LabelPrefix: h.labelPrefix,
+		Hosts:       make([]string, 0, len(h.endpoints)),
+		Items:       make([]Item, 0, len(xs))}
+	for _, e := range h.endpoints {
+		view.Hosts = append(view.Hosts, e.String())
+	}
 
+	// a container may have multiple endpoints
+	containers := map[string]struct{}{}
+	withJob := map[string]struct{}{}
+	explicitJob := map[string]struct{}{}
 	for _, x := range xs {
+		containers[x.ID] = struct{}{}
 		if x.HasJob {
-			view.WithJob++
+			withJob[x.ID] = struct{}{}
+			if !x.JobDerived {
+				explicitJob[x.ID] = struct{}{}
+			}
+			view.Endpoints++
+			if x.IsProbe {
+				view.Probes++
+			}
 
-			if x.IsExported() {
-				if x.HasExplicitPort {
+			if x.ExcludedReason != "" || x.DroppedByRelabelRule > 0 {
+				view.Excluded++
+			} else if x.IsExported() {
+				if x.HasExplicitPort && len(x.Problems) == 0 {
 					view.OKs++
 				} else {
 					view.Warnings++
@@ -95,15 +137,32 @@ func convert(xs []docker.Meta) View {
 
 		view.Items = append(view.Items,
 			Item{
-				Name:              x.Name,
-				Address:           x.Address,
-				Labels:            convertLabels(x.Labels),
-				HasJob:            x.HasJob,
-				IsExported:        x.IsExported(),
-				IsInTargetNetwork: x.IsInTargetNetwork,
-				HasTCPPorts:       x.HasTCPPorts,
-				HasExplicitPort:   x.HasExplicitPort})
+				Name:                 x.Name,
+				Endpoint:             x.Endpoint,
+				Address:              x.Address,
+				ScrapeURL:            x.ScrapeURL(),
+				Labels:               convertLabels(x.Labels),
+				HasJob:               x.HasJob,
+				JobDerived:           x.JobDerived,
+				IsProbe:              x.IsProbe,
+				IsExported:           x.IsExported(),
+				IsInTargetNetwork:    x.IsInTargetNetwork,
+				HasInvalidNetwork:    x.HasInvalidScrapeNetwork,
+				IsHostNetwork:        x.IsHostNetwork,
+				ExcludedReason:       x.ExcludedReason,
+				NoIPAddress:          x.NoIPAddress,
+				NoAddressInFamily:    x.NoAddressInFamily,
+				IsStale:              x.IsStale,
+				HasTCPPorts:          x.HasTCPPorts,
+				HasExplicitPort:      x.HasExplicitPort,
+				DroppedLabels:        x.DroppedLabels,
+				DroppedByRelabelRule: x.DroppedByRelabelRule,
+				TemplateError:        x.TemplateError,
+				Problems:             x.Problems})
 	}
+	view.Total = len(containers)
+	view.WithJob = len(withJob)
+	view.DerivedJob = len(withJob) - len(explicitJob)
 	return view
 }
 
@@ -8,10 +8,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func Serve(addr string, metas <-chan []docker.Meta) {
+func Serve(addr, labelPrefix string, endpoints []docker.Endpoint, metas <-chan []docker.Meta) {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
-	mux.Handle("/containers", StartHandler(metas))
+	mux.Handle("/containers", StartHandler(labelPrefix, endpoints, metas))
 	mux.Handle("/static/", cacheForever(http.StripPrefix("/static", http.FileServer(http.FS(static.Content)))))
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/containers", http.StatusSeeOther)